@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/arovesto/gio"
+	"github.com/arovesto/gio/logging"
 	"github.com/arovesto/gio/math"
 )
 
@@ -38,6 +39,8 @@ type WebCanvas struct {
 	grace chan struct{}
 
 	fps float64
+
+	Log logging.Logger
 }
 
 func NewCanvas(cfg gio.Config) *WebCanvas {
@@ -48,6 +51,7 @@ func NewCanvas(cfg gio.Config) *WebCanvas {
 		jsImages: map[string]js.Value{},
 		grace:    make(chan struct{}),
 		fps:      1000 / float64(cfg.FPSCap),
+		Log:      logging.Default(),
 	}
 	c.Document = c.Window.Get("document")
 	c.Body = c.Document.Get("body")
@@ -90,7 +94,8 @@ func (c *WebCanvas) Start(callback func(c *WebCanvas, duration time.Duration) bo
 					close(c.grace)
 				}
 				if float64(time.Since(cbkStart).Milliseconds()) > c.fps {
-					log.Println("overload! callback is not keeping up, late on ", float64(time.Since(cbkStart).Milliseconds())-c.fps)
+					late := time.Since(cbkStart) - time.Duration(c.fps*float64(time.Millisecond))
+					c.Log.Repeated(logging.WarnLevel, "overload! callback is not keeping up", logging.F("late", late))
 				}
 				last = now
 			}
@@ -5,9 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"net/url"
 	"os"
-	"strconv"
+	"strings"
 	"syscall/js"
 	"time"
 
@@ -18,68 +18,207 @@ import (
 	"github.com/arovesto/gio/elements"
 	"github.com/arovesto/gio/event"
 	"github.com/arovesto/gio/input"
+	"github.com/arovesto/gio/logging"
 	"github.com/arovesto/gio/server"
 )
 
-func RunClient(fps int, assetsPath string) {
+const (
+	// how often client tries to get back to the game after connection is lost
+	reconnectInterval = time.Second
+	// input is sent once a server tick, more often is wasted and may hit the server's rate limit
+	inputInterval = time.Second / server.DefaultTickRate
+)
+
+// Option configures the client
+type Option func(*options)
+
+type options struct {
+	interpolate bool
+	delay       time.Duration
+	log         logging.Logger
+}
+
+// WithInterpolation draws elements of other players delay behind the server, moving them smoothly between
+// the received states instead of simulating them locally. Zero delay is DefaultInterpolationDelay
+func WithInterpolation(delay time.Duration) Option {
+	return func(o *options) {
+		o.interpolate = true
+		o.delay = delay
+	}
+}
+
+// WithLogger sets logger of the client, it writes to the browser console by default
+func WithLogger(l logging.Logger) Option {
+	return func(o *options) {
+		o.log = l
+	}
+}
+
+func RunClient(fps int, assetsPath string, opts ...Option) {
+	o := options{log: logging.Default()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	lg := o.log
 	r := canvas.NewCanvas(gio.Config{Server: assetsPath, FPSCap: fps})
+	r.Log = lg
 	ctx := context.Background()
 
-	conn, _, err := websocket.Dial(ctx, fmt.Sprintf("ws://%s/socket", js.Global().Get("location").Get("host").String()), nil)
+	conn, err := dial(ctx, "")
 	if err != nil {
 		panic(fmt.Errorf("failed to create connection: %w", err))
 	}
-	var room server.Room
-	var me elements.Playable
-
-	inner := func() bool {
-		c, cancel := context.WithTimeout(ctx, time.Millisecond)
-		defer cancel()
-		_, data, err := conn.Read(c)
-		if err != nil {
-			if errors.Is(err, context.DeadlineExceeded) {
-				return true
-			}
-			log.Println("err read", err)
-			return false
+	codec := event.CodecFor(conn.Subprotocol())
+	msgType := websocket.MessageText
+	if codec.Binary() {
+		msgType = websocket.MessageBinary
+	}
+	connected := true
+	var token string // to get the player back after reconnection
+	var resuming bool
+	var lastDial time.Time
+	reconnect := func() {
+		if time.Since(lastDial) < reconnectInterval {
+			return
 		}
-		e, err := event.ParseEvent(data)
+		lastDial = time.Now()
+		c, err := dial(ctx, token)
 		if err != nil {
-			log.Println("failed parse event", err)
-			return false
+			lg.Warn("failed to reconnect", logging.Err(err))
+			return
 		}
+		conn, connected, resuming = c, true, token != ""
+		codec = event.CodecFor(conn.Subprotocol())
+		msgType = websocket.MessageText
+		if codec.Binary() {
+			msgType = websocket.MessageBinary
+		}
+	}
+	var room server.Room
+	room.SetLogger(lg)
+	var me elements.Playable
+	var watcher *spectator // camera of the client who is a spectator
+	pred := predictor{log: lg}
+	var ip *interpolator
+	var lastState time.Time // server time of the last received state, what is drawn if nothing is interpolated
+	if o.interpolate {
+		ip = newInterpolator(o.delay)
+	}
+
+	handle := func(e event.Event, at time.Time) {
 		switch e.Type {
 		case "room":
 			input.ResetPressed()
-			if err = room.SetState(e.Payload); err != nil {
-				log.Println("failed to set room state", err)
+			if err := room.SetState(e.Payload); err != nil {
+				lg.Error("failed to set room state", logging.Err(err))
 				room = server.Room{}
+				room.SetLogger(lg)
 			}
 			room.State = server.Web
 			me = nil // room changed so player is to
+			watcher = nil
+			pred.reset()
+			if ip != nil {
+				ip.reset()
+			}
 		case "assign":
 			if room.Type == "" {
 				break // room is not specified
 			}
-			id, err := strconv.Atoi(string(e.Payload))
-			if err != nil {
-				log.Println("failed to parse id for assign", err)
-				return false
+			var a event.Assign
+			if err := json.Unmarshal(e.Payload, &a); err != nil {
+				lg.Error("failed to parse assign", logging.Err(err))
+				return
 			}
+			token, resuming = a.Token, false
 			var ok bool
-			me, ok = room.GetElement(id).(elements.Playable)
+			me, ok = room.GetElement(a.ID).(elements.Playable)
 			if !ok {
-				log.Println("failed to locate the player even if arrived", id)
+				lg.Error("failed to locate the player even if arrived", logging.Player(a.ID))
+			}
+		case server.QueueEventType:
+			var st server.QueueStatus
+			if err := json.Unmarshal(e.Payload, &st); err != nil {
+				lg.Error("failed to parse queue status", logging.Err(err))
+				return
 			}
+			lg.Info("waiting in the queue", logging.F("pool", st.Pool), logging.F("position", st.Position),
+				logging.F("eta", time.Duration(st.ETA)*time.Millisecond))
+		case "spectate":
+			me, watcher = nil, &spectator{}
 		case "game-over":
 			me = nil
-			log.Println("Game exited")
+			lg.Info("game exited")
 			// TODO think something better (maybe game should have something custom for that matter)
 			os.Exit(0)
 		default:
-			if err = room.ProcessEvent(e); err != nil {
-				log.Println("failed to process event", err)
+			if err := room.ProcessEvent(e); err != nil {
+				room.Logger().Warn("failed to process event", logging.F("event", e.Type), logging.Element(e.From), logging.Err(err))
+			}
+			if e.Type != "update" && e.Type != "patch" {
+				if e.Type == "deleted" && ip != nil {
+					ip.forget(e.From)
+				}
+				break
+			}
+			if me != nil && e.From == me.GetID() {
+				if p, ok := me.(elements.Predictable); ok && e.Seq != 0 {
+					pred.reconcile(p, e.Seq, &room)
+				}
+				break
 			}
+			if el, ok := room.GetElement(e.From).(elements.Interpolatable); ok && ip != nil {
+				ip.record(el, at)
+			}
+		}
+	}
+
+	inner := func() bool {
+		if !connected {
+			reconnect()
+			return true
+		}
+		c, cancel := context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+		_, data, err := conn.Read(c)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return true
+			}
+			lg.Warn("failed to read", logging.Err(err))
+			if resuming {
+				token = "" // player is not there anymore, join as a new one
+			}
+			connected = false
+			me = nil
+			return true
+		}
+		e, err := codec.Decode(data)
+		if err != nil {
+			lg.Error("failed to parse event", logging.Err(err))
+			return false
+		}
+		// single event frames are still fine, they are unpacked as a batch of one
+		b, err := event.Unpack(e)
+		if err != nil {
+			lg.Error("failed to unpack batch", logging.Err(err))
+			return false
+		}
+		// events are stamped with server time of their tick, frames without one get the estimated server time
+		now := time.Now()
+		at := now
+		if ip != nil {
+			at = ip.serverNow(now)
+			if b.Time != 0 {
+				at = time.Unix(0, b.Time*int64(time.Millisecond))
+				ip.sync(at, now)
+			}
+		}
+		if b.Time != 0 {
+			lastState = time.Unix(0, b.Time*int64(time.Millisecond))
+		}
+		for _, e := range b.Events {
+			handle(e, at)
 		}
 		return false
 	}
@@ -93,36 +232,79 @@ func RunClient(fps int, assetsPath string) {
 	}), 0)
 
 	js.Global().Call("setInterval", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		if me != nil {
+		if me != nil && connected {
 			i, err := me.Input()
 			if err != nil {
-				log.Println("failed to get player input", err)
+				lg.Error("failed to get player input", logging.Err(err))
 			}
 			if err := me.SetInput(i); err != nil {
-				log.Println("failed to set input", err)
+				lg.Error("failed to set input", logging.Err(err))
+			}
+			var seq uint32
+			if _, ok := me.(elements.Predictable); ok {
+				seq = pred.next(i)
+			}
+			// server judges hits of the input by what was on the screen
+			seen := lastState
+			if ip != nil {
+				seen = ip.shown(time.Now())
+			}
+			var seenMs int64
+			if !seen.IsZero() {
+				seenMs = seen.UnixNano() / int64(time.Millisecond)
 			}
-			eventRaw, err := json.Marshal(event.Event{
+			eventRaw, err := codec.Encode(event.Event{
 				Type:    "input",
 				Payload: i,
 				From:    me.GetID(),
+				Seq:     seq,
+				Time:    seenMs,
 			})
 			if err != nil {
-				log.Println("failed to marshal event", err)
+				lg.Error("failed to encode event", logging.Err(err))
 			}
-			if err = conn.Write(ctx, websocket.MessageText, eventRaw); err != nil {
-				log.Println("failed to send event", err)
+			if err = conn.Write(ctx, msgType, eventRaw); err != nil {
+				lg.Repeated(logging.WarnLevel, "failed to send event", logging.Err(err))
 			}
 		}
 		return nil
-	}), 1)
+	}), inputInterval.Milliseconds())
 
 	r.Start(func(c *canvas.WebCanvas, d time.Duration) (done bool) {
 		c.Clear()
 		if p, ok := me.(elements.PreDraw); ok {
 			p.PreDraw(c)
 		}
+		if watcher != nil {
+			watcher.update(&room, d)
+			watcher.PreDraw(c)
+		}
 		room.Update(d)
+		pred.advance(d)
+		if ip != nil {
+			ip.apply(&room, time.Now())
+		}
 		room.Draw(c)
 		return false
 	})
 }
+
+// dial connects to the server of the page, query of the page (e.g. "spectate") is passed to the server
+func dial(ctx context.Context, token string) (*websocket.Conn, error) {
+	location := js.Global().Get("location")
+	q, err := url.ParseQuery(strings.TrimPrefix(location.Get("search").String(), "?"))
+	if err != nil {
+		q = url.Values{}
+	}
+	if token != "" {
+		q.Set("resume", token)
+	}
+	u := fmt.Sprintf("ws://%s/socket", location.Get("host").String())
+	if len(q) != 0 {
+		u += "?" + q.Encode()
+	}
+	conn, _, err := websocket.Dial(ctx, u, &websocket.DialOptions{
+		Subprotocols: event.Subprotocols(),
+	})
+	return conn, err
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"time"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/math"
+	"github.com/arovesto/gio/server"
+)
+
+const (
+	DefaultInterpolationDelay = 100 * time.Millisecond
+
+	// how fast the estimation of server clock follows new samples, 1 is to trust the last one only
+	clockSmoothing = 0.1
+)
+
+type snapshot struct {
+	at     time.Time // server time of the state
+	values []math.Vector
+}
+
+// interpolator draws elements of other players delay behind the server time, between the two states
+// which are around that moment, so they move smoothly instead of jumping to every update
+type interpolator struct {
+	delay     time.Duration
+	offset    time.Duration // server clock minus local clock, latency included
+	synced    bool
+	snapshots map[int][]snapshot
+}
+
+func newInterpolator(delay time.Duration) *interpolator {
+	if delay <= 0 {
+		delay = DefaultInterpolationDelay
+	}
+	return &interpolator{delay: delay, snapshots: map[int][]snapshot{}}
+}
+
+// sync updates the estimation of the server clock by the time of the just received batch
+func (ip *interpolator) sync(serverTime, now time.Time) {
+	sample := serverTime.Sub(now)
+	if !ip.synced {
+		ip.offset, ip.synced = sample, true
+		return
+	}
+	ip.offset += time.Duration(float64(sample-ip.offset) * clockSmoothing)
+}
+
+func (ip *interpolator) serverNow(now time.Time) time.Time {
+	return now.Add(ip.offset)
+}
+
+// shown is server time of the states drawn now
+func (ip *interpolator) shown(now time.Time) time.Time {
+	return ip.serverNow(now).Add(-ip.delay)
+}
+
+// record remembers the state element has just got from the server
+func (ip *interpolator) record(el elements.Interpolatable, at time.Time) {
+	vs := el.Interpolated()
+	values := make([]math.Vector, len(vs))
+	for i, v := range vs {
+		values[i] = *v
+	}
+	id := el.GetID()
+	ss := ip.snapshots[id]
+	if len(ss) != 0 && !ss[len(ss)-1].at.Before(at) {
+		// several states of the same tick, the last one wins
+		ss[len(ss)-1].values = values
+		return
+	}
+	ip.snapshots[id] = append(ss, snapshot{at: at, values: values})
+}
+
+// apply moves recorded elements to where they were delay ago, must be called after the room is updated
+// so local simulation of the remote elements is overwritten
+func (ip *interpolator) apply(room *server.Room, now time.Time) {
+	at := ip.shown(now)
+	for id, ss := range ip.snapshots {
+		el, ok := room.GetElement(id).(elements.Interpolatable)
+		if !ok {
+			delete(ip.snapshots, id)
+			continue
+		}
+		// only the last state before the render time is needed to interpolate
+		i := 0
+		for i+1 < len(ss) && !ss[i+1].at.After(at) {
+			i++
+		}
+		ss = ss[i:]
+		ip.snapshots[id] = ss
+
+		vs := el.Interpolated()
+		if len(ss) == 1 || !at.After(ss[0].at) {
+			set(vs, ss[0].values) // no newer state yet, nothing is extrapolated
+			continue
+		}
+		a, b := ss[0], ss[1]
+		lerp(vs, a.values, b.values, float64(at.Sub(a.at))/float64(b.at.Sub(a.at)))
+	}
+}
+
+func (ip *interpolator) forget(id int) {
+	delete(ip.snapshots, id)
+}
+
+func (ip *interpolator) reset() {
+	ip.snapshots = map[int][]snapshot{}
+}
+
+// element shape may change between states (snake grows), only the common part is moved
+func set(vs []*math.Vector, values []math.Vector) {
+	for i := 0; i < len(vs) && i < len(values); i++ {
+		*vs[i] = values[i]
+	}
+}
+
+func lerp(vs []*math.Vector, a, b []math.Vector, t float64) {
+	for i := 0; i < len(vs) && i < len(a) && i < len(b); i++ {
+		*vs[i] = math.Lerp(a[i], b[i], t)
+	}
+}
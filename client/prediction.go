@@ -0,0 +1,67 @@
+package client
+
+import (
+	"time"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/logging"
+)
+
+// inputs server hasn't processed for that long are forgotten, so lost acks don't pile them up
+const maxPredicted = 512
+
+type predictedInput struct {
+	seq   uint32
+	input []byte
+	took  time.Duration // for how long the input was simulated on the client
+}
+
+// predictor keeps inputs server has not processed yet, so they can be applied again on top of the server state
+type predictor struct {
+	seq     uint32
+	pending []predictedInput
+	log     logging.Logger
+}
+
+// next numbers the input and remembers it
+func (p *predictor) next(input []byte) uint32 {
+	p.seq++
+	p.pending = append(p.pending, predictedInput{seq: p.seq, input: input})
+	if len(p.pending) > maxPredicted {
+		p.pending = p.pending[len(p.pending)-maxPredicted:]
+	}
+	return p.seq
+}
+
+// advance accounts frame simulated with the last input
+func (p *predictor) advance(d time.Duration) {
+	if len(p.pending) != 0 {
+		p.pending[len(p.pending)-1].took += d
+	}
+}
+
+// reconcile drops inputs server already processed and replays the rest on top of the server state
+func (p *predictor) reconcile(me elements.Predictable, ack uint32, processor elements.EventProcessor) {
+	i := 0
+	for i < len(p.pending) && p.pending[i].seq <= ack {
+		i++
+	}
+	p.pending = p.pending[i:]
+	if !me.Predict() {
+		return
+	}
+	// collisions are not replayed, they would happen twice for everyone else; the next frame resolves them
+	for _, in := range p.pending {
+		if err := me.SetInput(in.input); err != nil {
+			p.log.Warn("failed to replay input", logging.F("seq", in.seq), logging.Err(err))
+			continue
+		}
+		if err := me.Move(in.took, processor); err != nil {
+			p.log.Warn("failed to replay move", logging.F("seq", in.seq), logging.Err(err))
+		}
+	}
+}
+
+func (p *predictor) reset() {
+	p.pending = nil
+}
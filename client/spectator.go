@@ -0,0 +1,87 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/arovesto/gio/canvas"
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/input"
+	"github.com/arovesto/gio/math"
+	"github.com/arovesto/gio/server"
+)
+
+const spectatorCameraSpeed = 800.0
+
+// spectator is the camera of the client who watches the room without own element:
+// it follows one of the players, Tab switches to the next one, arrows move the camera freely.
+// Camera stays where it is while there is nobody to follow
+type spectator struct {
+	camera    math.Vector
+	following int
+	free      bool // arrows were pressed, Tab follows players again
+	tabDown   bool
+}
+
+func (sp *spectator) update(room *server.Room, d time.Duration) {
+	tab := input.Pressed[input.KEY_TAB]
+	if tab && !sp.tabDown {
+		sp.next(room)
+	}
+	sp.tabDown = tab
+
+	var dir math.Vector
+	switch {
+	case input.Pressed[input.KEY_RIGHT]:
+		dir.X = 1
+	case input.Pressed[input.KEY_LEFT]:
+		dir.X = -1
+	}
+	switch {
+	case input.Pressed[input.KEY_DOWN]:
+		dir.Y = 1
+	case input.Pressed[input.KEY_UP]:
+		dir.Y = -1
+	}
+	if dir.X != 0 || dir.Y != 0 {
+		sp.free = true
+		sp.camera = sp.camera.Add(dir.Mul(d.Seconds() * spectatorCameraSpeed))
+		return
+	}
+	if sp.free {
+		return
+	}
+	c, ok := room.GetElement(sp.following).(elements.Collidable)
+	if !ok {
+		// player left or is not there yet, watch somebody else
+		if !sp.next(room) {
+			return
+		}
+		c = room.GetElement(sp.following).(elements.Collidable)
+	}
+	sp.camera = math.CenterOf(math.BoundsOf(c.Collider()))
+}
+
+// next starts following the player after the current one, false if there is nobody to follow
+func (sp *spectator) next(room *server.Room) bool {
+	var players []int
+	for _, id := range room.Players() {
+		if _, ok := room.GetElement(id).(elements.Collidable); ok {
+			players = append(players, id)
+		}
+	}
+	if len(players) == 0 {
+		return false
+	}
+	sort.Ints(players)
+	i := sort.SearchInts(players, sp.following+1)
+	if i == len(players) {
+		i = 0
+	}
+	sp.following, sp.free = players[i], false
+	return true
+}
+
+func (sp *spectator) PreDraw(c canvas.Canvas) {
+	c.SetCameraCenter(sp.camera)
+}
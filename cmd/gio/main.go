@@ -0,0 +1,85 @@
+// gio is a tool for the servers made with the library:
+//
+//	gio replay [-max N] <recording>    replays recording of the room headless and reports divergences
+//
+// It knows element types and rooms of the demo, other games need their own copy with their packages imported
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/arovesto/gio/demo/entities"
+	"github.com/arovesto/gio/server"
+)
+
+// rooms are made the way the server makes them, so they have the same handlers
+var rooms = map[string]func() *server.Room{
+	"snake": entities.NewSnakeRoom,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "replay":
+		os.Exit(replay(os.Args[2:], os.Stdout))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gio replay [-max N] <recording>")
+	os.Exit(2)
+}
+
+// replay is 0 if recording is reproduced, 1 if replay diverged and 2 if it failed
+func replay(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	limit := fs.Int("max", 20, "divergences to print, zero prints all")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		usage()
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+	report, err := server.Replay(f, func(tp string) *server.Room {
+		if newRoom, ok := rooms[tp]; ok {
+			return newRoom()
+		}
+		return nil
+	})
+	if report != nil {
+		fmt.Fprintf(out, "%s room: %d ticks, %d events, %d checkpoints\n", report.RoomType, report.Ticks, report.Events, report.Checkpoints)
+		if !report.Complete {
+			fmt.Fprintln(out, "recording has no end, room didn't stop properly")
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay failed:", err)
+		return 2
+	}
+	if !report.Diverged() {
+		fmt.Fprintln(out, "no divergences")
+		return 0
+	}
+	for i, d := range report.Divergences {
+		if *limit > 0 && i == *limit {
+			fmt.Fprintf(out, "... %d more\n", len(report.Divergences)-i)
+			break
+		}
+		fmt.Fprintf(out, "tick %d: element %d %s %s\n", d.Tick, d.Element, d.Problem, d.Patch)
+	}
+	fmt.Fprintf(out, "%d divergences, the first one at tick %d\n", len(report.Divergences), report.Divergences[0].Tick)
+	return 1
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const DefaultPath = "config/config.toml"
+
+// log levels of Logging.Type
+var levels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+// queue policies and limit actions, they are named the same way as in server package
+var (
+	queuePolicies = map[string]bool{"drop-updates": true, "disconnect": true}
+	limitActions  = map[string]bool{"drop": true, "offense": true, "disconnect": true}
+)
+
+// Duration is time.Duration written as "5s" in the config
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = time.ParseDuration(string(text))
+	return
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+type General struct {
+	Address    string // server listens on it
+	Static     string // directory with index.html, assets and wasm of the client
+	TickRate   int    // simulation steps per second of rooms which don't set their own
+	ClientsCap int    // connections served at once, zero is not limited
+}
+
+type Logging struct {
+	Type string // level: debug, info, warn or error
+}
+
+// Websocket are limits of every client connection
+type Websocket struct {
+	SendQueue         int
+	QueuePolicy       string // drop-updates or disconnect
+	WriteTimeout      Duration
+	MaxFrame          int64
+	MessagesPerSecond float64
+	MessageBurst      int
+	BytesPerSecond    float64
+	ByteBurst         int
+	LimitAction       string // drop, offense or disconnect
+	InboxSize         int
+	MaxOffenses       int
+	ResumeGrace       Duration
+}
+
+// Admin is the admin API on /admin/, it is off without token
+type Admin struct {
+	Token string // clients send it as "Authorization: Bearer <token>"
+}
+
+// Storage is where persistent rooms keep their snapshots, they are not saved without Dir
+type Storage struct {
+	Dir      string
+	Keep     int      // versions of every snapshot
+	Interval Duration // how often rooms are saved, they are saved on stop too
+}
+
+// Recording is where every room records what happens with it, see gio replay. Nothing is recorded without Dir
+type Recording struct {
+	Dir string
+}
+
+// minAdminToken is the length of the shortest admin token, shorter ones are easy to guess
+const minAdminToken = 16
+
+type Config struct {
+	General   General
+	Logging   Logging
+	Websocket Websocket
+	Admin     Admin
+	Storage   Storage
+	Recording Recording
+}
+
+// Default is the config of the server without config file
+func Default() Config {
+	return Config{
+		General: General{
+			Address:  ":8080",
+			Static:   "static",
+			TickRate: 60,
+		},
+		Logging: Logging{Type: "info"},
+		Websocket: Websocket{
+			SendQueue:         256,
+			QueuePolicy:       "drop-updates",
+			WriteTimeout:      Duration{5 * time.Second},
+			MaxFrame:          16 << 10,
+			MessagesPerSecond: 120,
+			MessageBurst:      240,
+			BytesPerSecond:    64 << 10,
+			ByteBurst:         128 << 10,
+			LimitAction:       "drop",
+			InboxSize:         32,
+			MaxOffenses:       10,
+			ResumeGrace:       Duration{30 * time.Second},
+		},
+		Storage: Storage{
+			Keep:     5,
+			Interval: Duration{time.Minute},
+		},
+	}
+}
+
+// Load makes config from defaults, the config file, environment variables (GIO_ADDRESS etc.) and flags
+// in args, each one overrides the previous. Config file is DefaultPath unless -config flag says otherwise,
+// missing default file is fine, missing explicit one is an error
+func Load(args []string) (Config, error) {
+	c := Default()
+	fs := flag.NewFlagSet("gio", flag.ContinueOnError)
+	path := fs.String("config", DefaultPath, "path to the config file")
+	set := c.flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	explicit := false
+	fs.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "config"
+	})
+	if _, err := toml.DecodeFile(*path, &c); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return c, fmt.Errorf("failed to read config %s: %w", *path, err)
+		}
+	}
+	if err := c.env(); err != nil {
+		return c, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := set[f.Name]; ok {
+			apply()
+		}
+	})
+	return c, c.Validate()
+}
+
+// flags defines flags of every option, returned functions put their values to the config
+func (c *Config) flags(fs *flag.FlagSet) map[string]func() {
+	address := fs.String("address", "", "address to listen on")
+	static := fs.String("static", "", "directory with static files")
+	tickRate := fs.Int("tick-rate", 0, "simulation steps per second")
+	clientsCap := fs.Int("clients-cap", 0, "connections served at once")
+	level := fs.String("log-level", "", "debug, info, warn or error")
+	sendQueue := fs.Int("send-queue", 0, "messages queued for every client")
+	maxFrame := fs.Int64("max-frame", 0, "biggest frame client may send in bytes")
+	storageDir := fs.String("storage-dir", "", "directory for snapshots of persistent rooms")
+	recordingDir := fs.String("recording-dir", "", "directory for recordings of rooms")
+	return map[string]func(){
+		"address":       func() { c.General.Address = *address },
+		"static":        func() { c.General.Static = *static },
+		"tick-rate":     func() { c.General.TickRate = *tickRate },
+		"clients-cap":   func() { c.General.ClientsCap = *clientsCap },
+		"log-level":     func() { c.Logging.Type = *level },
+		"send-queue":    func() { c.Websocket.SendQueue = *sendQueue },
+		"max-frame":     func() { c.Websocket.MaxFrame = *maxFrame },
+		"storage-dir":   func() { c.Storage.Dir = *storageDir },
+		"recording-dir": func() { c.Recording.Dir = *recordingDir },
+	}
+}
+
+// env applies GIO_* environment variables
+func (c *Config) env() error {
+	strs := map[string]*string{
+		"GIO_ADDRESS":       &c.General.Address,
+		"GIO_STATIC":        &c.General.Static,
+		"GIO_LOG_LEVEL":     &c.Logging.Type,
+		"GIO_ADMIN_TOKEN":   &c.Admin.Token,
+		"GIO_STORAGE_DIR":   &c.Storage.Dir,
+		"GIO_RECORDING_DIR": &c.Recording.Dir,
+	}
+	for name, v := range strs {
+		if s, ok := os.LookupEnv(name); ok {
+			*v = s
+		}
+	}
+	ints := map[string]*int{
+		"GIO_TICK_RATE":   &c.General.TickRate,
+		"GIO_CLIENTS_CAP": &c.General.ClientsCap,
+		"GIO_SEND_QUEUE":  &c.Websocket.SendQueue,
+	}
+	for name, v := range ints {
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("bad %s: %w", name, err)
+		}
+		*v = i
+	}
+	if s, ok := os.LookupEnv("GIO_MAX_FRAME"); ok {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad GIO_MAX_FRAME: %w", err)
+		}
+		c.Websocket.MaxFrame = i
+	}
+	return nil
+}
+
+// Validate reports the first bad value of the config
+func (c Config) Validate() error {
+	g, w := c.General, c.Websocket
+	switch {
+	case g.Address == "":
+		return errors.New("address is empty")
+	case g.Static == "":
+		return errors.New("static directory is empty")
+	case g.TickRate <= 0 || g.TickRate > 1000:
+		return fmt.Errorf("tick rate %d is not in 1..1000", g.TickRate)
+	case g.ClientsCap < 0:
+		return fmt.Errorf("clients cap %d is negative", g.ClientsCap)
+	case !levels[c.Logging.Type]:
+		return fmt.Errorf("unknown log level %q", c.Logging.Type)
+	case w.SendQueue <= 0:
+		return fmt.Errorf("send queue %d is not positive", w.SendQueue)
+	case !queuePolicies[w.QueuePolicy]:
+		return fmt.Errorf("unknown queue policy %q", w.QueuePolicy)
+	case w.WriteTimeout.Duration <= 0:
+		return fmt.Errorf("write timeout %s is not positive", w.WriteTimeout)
+	case w.MaxFrame <= 0:
+		return fmt.Errorf("max frame %d is not positive", w.MaxFrame)
+	case w.MessagesPerSecond < 0 || w.BytesPerSecond < 0 || w.MessageBurst < 0 || w.ByteBurst < 0:
+		return errors.New("rate limits are negative")
+	case w.BytesPerSecond > 0 && w.ByteBurst > 0 && int64(w.ByteBurst) < w.MaxFrame:
+		return fmt.Errorf("byte burst %d is less than max frame %d, such frames would never pass", w.ByteBurst, w.MaxFrame)
+	case !limitActions[w.LimitAction]:
+		return fmt.Errorf("unknown limit action %q", w.LimitAction)
+	case w.InboxSize <= 0:
+		return fmt.Errorf("inbox size %d is not positive", w.InboxSize)
+	case w.MaxOffenses <= 0:
+		return fmt.Errorf("max offenses %d is not positive", w.MaxOffenses)
+	case w.ResumeGrace.Duration < 0:
+		return fmt.Errorf("resume grace %s is negative", w.ResumeGrace)
+	case c.Admin.Token != "" && len(c.Admin.Token) < minAdminToken:
+		return fmt.Errorf("admin token is shorter than %d characters", minAdminToken)
+	case c.Storage.Keep <= 0:
+		return fmt.Errorf("storage keep %d is not positive", c.Storage.Keep)
+	case c.Storage.Interval.Duration <= 0:
+		return fmt.Errorf("storage interval %s is not positive", c.Storage.Interval)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setenv sets environment variable for the test, go 1.16 has no t.Setenv
+func setenv(t *testing.T, name, value string) {
+	old, ok := os.LookupEnv(name)
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(name, old)
+		} else {
+			_ = os.Unsetenv(name)
+		}
+	})
+}
+
+func configFile(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := ioutil.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const fileConfig = `
+[General]
+Address = "localhost:9000"
+TickRate = 30
+
+[Websocket]
+SendQueue = 64
+WriteTimeout = "2s"
+
+[Storage]
+Dir = "saves"
+`
+
+func TestLoad(t *testing.T) {
+	path := configFile(t, fileConfig)
+	cases := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		check func(c Config) bool
+	}{
+		{"file", nil, nil, func(c Config) bool {
+			return c.General.Address == "localhost:9000" && c.General.TickRate == 30 && c.Websocket.SendQueue == 64 &&
+				c.Websocket.WriteTimeout.Duration == 2*time.Second && c.Storage.Dir == "saves"
+		}},
+		{"defaults under file", nil, nil, func(c Config) bool {
+			return c.General.Static == "static" && c.Websocket.MaxFrame == 16<<10 && c.Storage.Keep == 5
+		}},
+		{"env over file", map[string]string{"GIO_TICK_RATE": "20", "GIO_STORAGE_DIR": "env"}, nil, func(c Config) bool {
+			return c.General.TickRate == 20 && c.Storage.Dir == "env" && c.General.Address == "localhost:9000"
+		}},
+		{"flags over env", map[string]string{"GIO_TICK_RATE": "20", "GIO_ADDRESS": ":1"}, []string{"-tick-rate", "10"}, func(c Config) bool {
+			return c.General.TickRate == 10 && c.General.Address == ":1"
+		}},
+		{"flags over file", nil, []string{"-send-queue", "8", "-max-frame", "1024"}, func(c Config) bool {
+			return c.Websocket.SendQueue == 8 && c.Websocket.MaxFrame == 1024
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for k, v := range c.env {
+				setenv(t, k, v)
+			}
+			cfg, err := Load(append([]string{"-config", path}, c.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.check(cfg) {
+				t.Fatalf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	bad := configFile(t, "[General]\nTickRate = \"fast\"\n")
+	cases := []struct {
+		name string
+		env  map[string]string
+		args []string
+	}{
+		{"missing explicit file", nil, []string{"-config", filepath.Join(t.TempDir(), "missing.toml")}},
+		{"bad file", nil, []string{"-config", bad}},
+		{"bad env", map[string]string{"GIO_TICK_RATE": "fast"}, []string{"-config", configFile(t, "")}},
+		{"unknown flag", nil, []string{"-no-such-flag"}},
+		{"invalid value", nil, []string{"-config", configFile(t, ""), "-tick-rate", "0"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for k, v := range c.env {
+				setenv(t, k, v)
+			}
+			if _, err := Load(c.args); err == nil {
+				t.Fatal("config is loaded")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(c *Config)
+	}{
+		{"no address", func(c *Config) { c.General.Address = "" }},
+		{"zero tick rate", func(c *Config) { c.General.TickRate = 0 }},
+		{"huge tick rate", func(c *Config) { c.General.TickRate = 5000 }},
+		{"negative clients cap", func(c *Config) { c.General.ClientsCap = -1 }},
+		{"unknown log level", func(c *Config) { c.Logging.Type = "loud" }},
+		{"zero send queue", func(c *Config) { c.Websocket.SendQueue = 0 }},
+		{"zero inbox", func(c *Config) { c.Websocket.InboxSize = 0 }},
+		{"unknown queue policy", func(c *Config) { c.Websocket.QueuePolicy = "ignore" }},
+		{"byte burst under max frame", func(c *Config) { c.Websocket.ByteBurst = 10 }},
+		{"negative rate", func(c *Config) { c.Websocket.MessagesPerSecond = -1 }},
+		{"short admin token", func(c *Config) { c.Admin.Token = "short" }},
+		{"zero keep", func(c *Config) { c.Storage.Keep = 0 }},
+		{"zero storage interval", func(c *Config) { c.Storage.Interval.Duration = 0 }},
+	}
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config is bad: %v", err)
+	}
+	for _, c := range cases {
+		cfg := Default()
+		c.change(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: config is valid", c.name)
+		}
+	}
+}
+
+func TestShippedConfigIsValid(t *testing.T) {
+	if _, err := Load([]string{"-config", "config.toml"}); err != nil {
+		t.Fatal(err)
+	}
+}
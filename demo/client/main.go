@@ -6,5 +6,5 @@ import (
 )
 
 func main() {
-	client.RunClient(60, "/static/assets")
+	client.RunClient(60, "/static/assets", client.WithInterpolation(client.DefaultInterpolationDelay))
 }
@@ -59,6 +59,7 @@ func (c *Controller) Move(duration time.Duration, processor elements.EventProces
 	if len(players) == 0 {
 		return nil
 	}
+	rnd := processor.Rand()
 	for s := range c.Snakes {
 		el := processor.GetElement(s)
 		if el == nil {
@@ -104,15 +105,15 @@ func (c *Controller) Move(duration time.Duration, processor elements.EventProces
 			snakes = 10
 		}
 		for i := 0; i < snakes; i++ {
-			spd := math.ClampF(math.RandomF(float64(c.SnakesLen*2), c.SnakesHeadRadius), 5, 20)
+			spd := math.ClampF(rnd.RandomF(float64(c.SnakesLen*2), c.SnakesHeadRadius), 5, 20)
 			id := processor.NewID()
 			processor.NewElement(&Snake{
 				Layer:    7,
-				Orbs:     genOrbs(c.Arena, math.Random(c.SnakesLen-2, c.SnakesLen+2), c.SnakesHeadRadius),
+				Orbs:     genOrbs(rnd, c.Arena, rnd.Random(c.SnakesLen-2, c.SnakesLen+2), c.SnakesHeadRadius),
 				ID:       id,
 				MaxSpeed: spd,
 				MaxAngle: 0.05 * math.ClampF(float64(c.Level)/3, 1, 3),
-				DoDamage: math.ClampF(math.RandomF(float64(c.SnakesLen)/2, math.ClampF(spd/5, float64(c.SnakesLen)/2, 3)), 0.5, 3),
+				DoDamage: math.ClampF(rnd.RandomF(float64(c.SnakesLen)/2, math.ClampF(spd/5, float64(c.SnakesLen)/2, 3)), 0.5, 3),
 			})
 			c.Snakes[id] = struct{}{}
 		}
@@ -121,11 +122,11 @@ func (c *Controller) Move(duration time.Duration, processor elements.EventProces
 		el := processor.GetElement(i)
 		if el != nil {
 			p, ok := el.(*Guy)
-			if ok && p.HP < 5 && time.Since(c.HelpCoolDown) > helpDuration {
-				c.HelpCoolDown = time.Now()
+			if ok && p.HP < 5 && processor.Now().Sub(c.HelpCoolDown) > helpDuration {
+				c.HelpCoolDown = processor.Now()
 				processor.NewElement(&Apple{
 					ID:  processor.NewID(),
-					Pos: math.Sphere{R: 30, Center: math.RandomInBox(math.Box{Corner: p.Position.Corner.Sub(math.Vector{X: 500, Y: 500}), Size: math.Vector{X: 1000, Y: 1000}})},
+					Pos: math.Sphere{R: 30, Center: rnd.RandomInBox(math.Box{Corner: p.Position.Corner.Sub(math.Vector{X: 500, Y: 500}), Size: math.Vector{X: 1000, Y: 1000}})},
 				})
 			}
 		}
@@ -133,20 +134,20 @@ func (c *Controller) Move(duration time.Duration, processor elements.EventProces
 	return nil
 }
 
-func genOrbs(where math.Box, len int, rad float64) (r []math.Sphere) {
+func genOrbs(rnd *math.Rand, where math.Box, len int, rad float64) (r []math.Sphere) {
 	if len <= 3 {
 		len = 3
 	}
 
-	p := math.RandomInBox(where)
+	p := rnd.RandomInBox(where)
 
 	for i := 0; i < len; i++ {
 		if i == 0 {
 			r = append(r, math.Sphere{R: rad, Center: p})
-			p = p.Add(math.Vector{X: math.RandomF(-2*rad, 2*rad), Y: math.RandomF(-2*rad, 2*rad)})
+			p = p.Add(math.Vector{X: rnd.RandomF(-2*rad, 2*rad), Y: rnd.RandomF(-2*rad, 2*rad)})
 		} else {
 			r = append(r, math.Sphere{R: rad * 0.75, Center: p})
-			p = p.Add(math.Vector{X: math.RandomF(-1.5*rad, 1.5*rad), Y: math.RandomF(-1.5*rad, 1.5*rad)})
+			p = p.Add(math.Vector{X: rnd.RandomF(-1.5*rad, 1.5*rad), Y: rnd.RandomF(-1.5*rad, 1.5*rad)})
 		}
 	}
 	return
@@ -62,6 +62,9 @@ type Guy struct {
 	LastKnownPosition math.Box
 
 	I GuyInput
+
+	rewound bool      // sword hits of this tick were judged by what the player saw, Collide shouldn't judge them again
+	now     time.Time // of the room at the last Move, Collide has nobody to ask
 }
 
 func NewGuy(id int, pos math.Vector) *Guy {
@@ -89,18 +92,18 @@ func (g *Guy) Collide(other elements.Collidable) error {
 	if t.Dead {
 		return nil
 	}
-	if g.Attacking {
+	if g.Attacking && !g.rewound {
 		info := math.Collide(g.SwordPosition, t.Orbs)
 		if info.Collided {
-			t.Damage()
+			t.Damage(g.now)
 		}
 	}
 	info := math.Collide(math.Box{Corner: g.Position.Corner.Add(math.Vector{X: g.Position.Size.X * 0.25}), Size: g.Position.Size.Add(math.Vector{X: -g.Position.Size.X * 0.25})}, t.Orbs)
 	if info.Collided && !g.Flying && !t.Damaged {
-		if time.Since(g.DamageCoolDown) > time.Millisecond*1000 {
+		if g.now.Sub(g.DamageCoolDown) > time.Millisecond*1000 {
 			g.HP -= t.DoDamage
 			t.IncreaseLength()
-			g.DamageCoolDown = time.Now()
+			g.DamageCoolDown = g.now
 		}
 	}
 	return nil
@@ -142,6 +145,8 @@ func (g *Guy) Draw(c canvas.Canvas) {
 }
 
 func (g *Guy) Move(duration time.Duration, processor elements.EventProcessor) error {
+	g.rewound = false
+	g.now = processor.Now()
 	if g.HP < 0 {
 		g.HP = 0
 		return processor.ProcessEvent(event.Event{Type: "lose", From: g.ID})
@@ -151,31 +156,31 @@ func (g *Guy) Move(duration time.Duration, processor elements.EventProcessor) er
 	}
 	switch g.AnimState {
 	case GuyIdle:
-		if time.Since(g.T) > guyAnimDurationIdle {
+		if g.now.Sub(g.T) > guyAnimDurationIdle {
 			if g.TextureShape.Corner.X == 0 {
 				g.TextureShape.Corner.X = g.TextureShape.Size.X * 8
 			} else {
 				g.TextureShape.Corner.X = 0
 			}
-			g.T = time.Now()
+			g.T = g.now
 		}
 	case GuyMoveRight, GuyMoveDown, GuyMoveLeft, GuyMoveUp:
-		if time.Since(g.T) > guyAnimDurationGo {
+		if g.now.Sub(g.T) > guyAnimDurationGo {
 			if g.TextureShape.Corner.X >= g.TextureShape.Size.X*7 {
 				g.TextureShape.Corner.X = 0
 			} else {
 				g.TextureShape.Corner.X += g.TextureShape.Size.X
 			}
-			g.T = time.Now()
+			g.T = g.now
 		}
 	case GuyJump:
-		if time.Since(g.T) > guyAnimDurationJump {
+		if g.now.Sub(g.T) > guyAnimDurationJump {
 			g.TextureShape.Corner.X = 0
 			g.JumpDirection = math.Vector{Y: -jumpSpeed}
 			g.Flying = true
 			g.AnimState = GuyIdle
 			g.LastKnownPosition = g.Position
-			g.T = time.Now()
+			g.T = g.now
 		}
 	}
 	moveSpeed := guyMoveSpeed
@@ -201,13 +206,13 @@ func (g *Guy) Move(duration time.Duration, processor elements.EventProcessor) er
 		g.AnimState = GuyIdle
 	}
 
-	if !g.Attacking && g.I.Attack && time.Since(g.LastAttack) > guyAnimAttackCoolDown {
+	if !g.Attacking && g.I.Attack && g.now.Sub(g.LastAttack) > guyAnimAttackCoolDown {
 		g.Attacking = true
-		g.LastAttack = time.Now()
+		g.LastAttack = g.now
 	}
-	if g.Attacking && time.Since(g.LastAttack) > guyAnimAttackDuration {
+	if g.Attacking && g.now.Sub(g.LastAttack) > guyAnimAttackDuration {
 		g.Attacking = false
-		g.LastAttack = time.Now()
+		g.LastAttack = g.now
 	}
 
 	if g.Flying {
@@ -238,9 +243,30 @@ func (g *Guy) Move(duration time.Duration, processor elements.EventProcessor) er
 		g.SwordTextureShape.Corner.X = g.SwordTextureShape.Size.X * 3
 	}
 
+	if lc, ok := processor.(elements.LagCompensator); ok && g.Attacking {
+		g.hitSeen(lc, processor)
+	}
 	return nil
 }
 
+// hitSeen hits snakes where the player saw them, not where they are on the server now
+func (g *Guy) hitSeen(lc elements.LagCompensator, processor elements.EventProcessor) {
+	for id, el := range processor.GetElements() {
+		t, ok := el.(*Snake)
+		if !ok || t.Dead {
+			continue
+		}
+		orbs, ok := lc.ColliderSeenBy(g.ID, id)
+		if !ok {
+			continue
+		}
+		g.rewound = true
+		if math.Collide(g.SwordPosition, orbs).Collided {
+			t.Damage(g.now)
+		}
+	}
+}
+
 func (g *Guy) GetID() int {
 	return g.ID
 }
@@ -282,6 +308,14 @@ func (g *Guy) SetInput(bytes []byte) error {
 	return json.Unmarshal(bytes, &g.I)
 }
 
+func (g *Guy) Interpolated() []*math.Vector {
+	return []*math.Vector{&g.Position.Corner, &g.SwordPosition.Corner}
+}
+
+func (g *Guy) Predict() bool {
+	return g.HP > 0
+}
+
 func init() {
 	elements.GenElements[GuyType] = func() elements.Element {
 		return &Guy{}
@@ -90,7 +90,7 @@ func (s *Snake) Collider() math.Shape {
 }
 
 func (s *Snake) Move(duration time.Duration, processor elements.EventProcessor) error {
-	if time.Since(s.DamageCoolDown) > snakeDamageCoolDown && !s.Dead {
+	if processor.Now().Sub(s.DamageCoolDown) > snakeDamageCoolDown && !s.Dead {
 		s.Damaged = false
 	}
 
@@ -132,6 +132,13 @@ func (s *Snake) Move(duration time.Duration, processor elements.EventProcessor)
 	return nil
 }
 
+func (s *Snake) Interpolated() (r []*math.Vector) {
+	for i := range s.Orbs {
+		r = append(r, &s.Orbs[i].Center)
+	}
+	return
+}
+
 func (s *Snake) GetLayer() int {
 	return s.Layer
 }
@@ -141,9 +148,10 @@ func (s *Snake) IncreaseLength() {
 	s.Orbs = append(s.Orbs, math.Sphere{R: last.R, Center: last.Center.Add(s.Vel.NormalizedTimes(-(last.R*2 + dist)))})
 }
 
-func (s *Snake) Damage() {
-	if time.Since(s.DamageCoolDown) > snakeDamageCoolDown {
-		s.DamageCoolDown = time.Now()
+// Damage takes the last orb of the snake, now is time of the room
+func (s *Snake) Damage(now time.Time) {
+	if now.Sub(s.DamageCoolDown) > snakeDamageCoolDown {
+		s.DamageCoolDown = now
 		s.Damaged = true
 		if len(s.Orbs) == 1 {
 			s.Dead = true
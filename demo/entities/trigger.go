@@ -3,11 +3,12 @@ package entities
 import (
 	"encoding/json"
 	"image/color"
-	"log"
 	"time"
 
 	"github.com/arovesto/gio/canvas"
 	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
 	"github.com/arovesto/gio/math"
 	"github.com/arovesto/gio/misc"
 	"github.com/arovesto/gio/server"
@@ -73,37 +74,14 @@ func (t *Trigger) Move(duration time.Duration, processor elements.EventProcessor
 	if t.Starting {
 		t.Starting = false
 
-		room := server.NewBasicRoom(misc.NewID(), "snake", []elements.Element{
-			NewController(0, math.Box{Size: math.Vector{X: 256 * 15, Y: 144 * 15}}),
-			&elements.StaticBackground{
-				Where:     math.Box{Size: math.Vector{X: 256 * 15, Y: 144 * 15}},
-				Texture:   math.Box{Size: math.Vector{X: 1280, Y: 720}},
-				TextureID: "game-background.png",
-				ID:        1,
-			},
-			&elements.Wall{
-				ID:    2,
-				Where: math.Box{Corner: math.Vector{X: 43 * 15, Y: 42 * 15}, Size: math.Vector{X: 10, Y: 60 * 15}},
-			},
-			&elements.Wall{
-				ID:    3,
-				Where: math.Box{Corner: math.Vector{X: 176 * 15, Y: 42 * 15}, Size: math.Vector{X: 10, Y: 60 * 15}},
-			},
-			&elements.Wall{
-				ID:    4,
-				Where: math.Box{Corner: math.Vector{X: 43 * 15, Y: 42 * 15}, Size: math.Vector{X: 130 * 15, Y: 10}},
-			},
-			&elements.Wall{
-				ID:    5,
-				Where: math.Box{Corner: math.Vector{X: 43 * 15, Y: 102 * 15}, Size: math.Vector{X: 130 * 15, Y: 10}},
-			},
-		})
-
+		r, ok := processor.(*server.Room)
+		if !ok || Matchmaker == nil {
+			logging.Default().Warn("nobody can start the game", logging.Element(t.ID))
+			return nil
+		}
 		for pl := range t.Ready {
-			id := room.NewID()
-			room.NewElement(NewGuy(id, math.Vector{X: 1500, Y: 1000}))
-			if err := processor.Transfer(pl, room); err != nil {
-				log.Println("failed to transfer guy", pl, "to new room", err)
+			if err := Matchmaker.Enqueue(SnakePool, r, pl); err != nil {
+				r.Logger().Warn("failed to enqueue guy", logging.Player(pl), logging.Err(err))
 			}
 		}
 		t.Ready = map[int]struct{}{}
@@ -111,6 +89,84 @@ func (t *Trigger) Move(duration time.Duration, processor elements.EventProcessor
 	return nil
 }
 
+// Matchmaker sends players gathered by triggers to the snake rooms, it is set by the server
+var Matchmaker *server.Matchmaker
+
+const (
+	SnakePool       = "snake"
+	SnakeMaxPlayers = 4
+)
+
+// NewSnakeRoom is an instance of the snake game for the matchmaker
+func NewSnakeRoom() *server.Room {
+	return server.NewBasicRoom(misc.NewID(), "snake", []elements.Element{
+		NewController(0, math.Box{Size: math.Vector{X: 256 * 15, Y: 144 * 15}}),
+		&elements.StaticBackground{
+			Where:     math.Box{Size: math.Vector{X: 256 * 15, Y: 144 * 15}},
+			Texture:   math.Box{Size: math.Vector{X: 1280, Y: 720}},
+			TextureID: "game-background.png",
+			ID:        1,
+		},
+		&elements.Wall{
+			ID:    2,
+			Where: math.Box{Corner: math.Vector{X: 43 * 15, Y: 42 * 15}, Size: math.Vector{X: 10, Y: 60 * 15}},
+		},
+		&elements.Wall{
+			ID:    3,
+			Where: math.Box{Corner: math.Vector{X: 176 * 15, Y: 42 * 15}, Size: math.Vector{X: 10, Y: 60 * 15}},
+		},
+		&elements.Wall{
+			ID:    4,
+			Where: math.Box{Corner: math.Vector{X: 43 * 15, Y: 42 * 15}, Size: math.Vector{X: 130 * 15, Y: 10}},
+		},
+		&elements.Wall{
+			ID:    5,
+			Where: math.Box{Corner: math.Vector{X: 43 * 15, Y: 102 * 15}, Size: math.Vector{X: 130 * 15, Y: 10}},
+		},
+	}, snakeRoomOptions()...)
+}
+
+// snake room sends players to the well-known "lose" and "win" rooms of its registry
+func snakeRoomOptions() []server.RoomOption {
+	return []server.RoomOption{
+		server.WithLagCompensation(server.DefaultMaxRewind),
+		server.WithPlayers(1, SnakeMaxPlayers),
+		// free guy is taken if there is one, room capacity is checked before
+		server.WithJoinPolicy(func(playable map[int]elements.Playable, assigned map[int]struct{}, r *server.Room) (int, error) {
+			for id := range playable {
+				if _, ok := assigned[id]; !ok {
+					return id, nil
+				}
+			}
+			id := r.NewID()
+			r.NewElement(NewGuy(id, math.Vector{X: 1500, Y: 1000}))
+			return id, nil
+		}),
+		server.WithEventHandler("lose", func(e event.Event, r *server.Room) error {
+			return r.Transfer(e.From, lookup(r, "lose"))
+		}),
+		server.WithEventHandler("win", func(e event.Event, r *server.Room) error {
+			// busy player doesn't keep the others in the finished game
+			var err error
+			for _, p := range r.Players() {
+				if tErr := r.Transfer(p, lookup(r, "win")); tErr != nil && err == nil {
+					err = tErr
+				}
+			}
+			return err
+		}),
+	}
+}
+
+// lookup finds well-known room from the registry of the room r
+func lookup(r *server.Room, key string) *server.Room {
+	if r.Registry() == nil {
+		return nil
+	}
+	room, _ := r.Registry().Lookup(key)
+	return room
+}
+
 func init() {
 	elements.GenElements[TriggerType] = func() elements.Element {
 		return &Trigger{}
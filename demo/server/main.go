@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/arovesto/gio/config"
 	"github.com/arovesto/gio/demo/entities"
 	"github.com/arovesto/gio/elements"
-	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
 	"github.com/arovesto/gio/math"
 	"github.com/arovesto/gio/server"
 )
@@ -54,7 +62,11 @@ var lobby = server.NewBasicRoom(0, "lobby", []elements.Element{
 		ID:        7,
 		Layer:     6,
 	},
-})
+}, server.WithJoinPolicy(func(playable map[int]elements.Playable, assigned map[int]struct{}, r *server.Room) (int, error) {
+	id := r.NewID()
+	r.NewElement(entities.NewGuy(id, math.Vector{X: 1500, Y: 1000}))
+	return id, nil
+}), server.WithPersistence(0))
 
 var loseLobby = server.NewBasicRoom(0, "game-over-lobby", []elements.Element{
 	&elements.StaticBackground{
@@ -62,7 +74,7 @@ var loseLobby = server.NewBasicRoom(0, "game-over-lobby", []elements.Element{
 		TextureID: "lose.png",
 		ID:        0,
 	},
-})
+}, server.WithJoinPolicy(gameOverJoin))
 
 var winLobby = server.NewBasicRoom(0, "game-over-lobby", []elements.Element{
 	&elements.StaticBackground{
@@ -70,47 +82,65 @@ var winLobby = server.NewBasicRoom(0, "game-over-lobby", []elements.Element{
 		TextureID: "win.png",
 		ID:        0,
 	},
-})
+}, server.WithJoinPolicy(gameOverJoin))
+
+func gameOverJoin(playable map[int]elements.Playable, assigned map[int]struct{}, r *server.Room) (int, error) {
+	id := r.NewID()
+	r.NewElement(&entities.GameOverPlayer{NoOpPlayer: elements.NoOpPlayer{ID: id}, Lobby: lobby})
+	return id, nil
+}
 
 func main() {
 	// TODO EventProcessor should be a Element interface with Subscribtions() map[string]struct{} and Process(e Event) error or smth
-	// TODO after creating a "Room storage" transfer will use well-known id, so no need in "lose"
-	// TODO DELETE ROOM WHEN NO PLAYERS PRESENT
-	server.EventsProcessors["snake"] = map[string]func(e event.Event, r *server.Room) error{
-		"lose": func(e event.Event, r *server.Room) error {
-			return r.Transfer(e.From, loseLobby)
-		},
-		"win": func(e event.Event, r *server.Room) error {
-			for _, p := range r.Players() {
-				if err := r.Transfer(p, winLobby); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
 	}
-	server.PlayerChoiceFunctions["lobby"] = func(playable map[int]elements.Playable, assigned map[int]struct{}, r *server.Room) (int, error) {
-		id := r.NewID()
-		r.NewElement(entities.NewGuy(id, math.Vector{X: 1500, Y: 1000}))
-		return id, nil
+	srv, err := server.NewServer(cfg, func(r *http.Request, rooms map[string]*server.Room) (*server.Room, bool, error) {
+		// ?spectate=snake-1 watches the game, any other value watches the lobby
+		spectate := r.URL.Query().Get("spectate")
+		if room, ok := rooms[spectate]; ok && spectate != "" {
+			return room, true, nil
+		}
+		room, ok := rooms["lobby"]
+		if !ok {
+			return nil, false, fmt.Errorf("%w: lobby is stopped", server.RoomNotFound)
+		}
+		return room, spectate != "", nil
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
-	server.PlayerChoiceFunctions["game-over-lobby"] = func(playable map[int]elements.Playable, assigned map[int]struct{}, r *server.Room) (int, error) {
-		id := r.NewID()
-		r.NewElement(&entities.GameOverPlayer{NoOpPlayer: elements.NoOpPlayer{ID: id}, Lobby: lobby})
-		return id, nil
+	entities.Matchmaker = srv.Matchmaker()
+	if err := srv.Matchmaker().AddPool(entities.SnakePool, server.Pool{
+		MinPlayers: 1,
+		MaxPlayers: entities.SnakeMaxPlayers,
+		Wait:       3 * time.Second,
+		NewRoom:    entities.NewSnakeRoom,
+	}); err != nil {
+		panic(err)
 	}
-	server.PlayerChoiceFunctions["snake"] = func(playable map[int]elements.Playable, assigned map[int]struct{}, r *server.Room) (int, error) {
-		for id := range playable {
-			if _, ok := assigned[id]; !ok {
-				return id, nil
-			}
+	for key, r := range map[string]*server.Room{"lobby": lobby, "lose": loseLobby, "win": winLobby} {
+		if err := srv.Rooms().Register(key, r); err != nil {
+			panic(err)
 		}
-		return 0, server.RoomFull
 	}
 
-	if err := http.ListenAndServe(`:8080`, server.NewServer(func(rooms map[string]*server.Room) (*server.Room, error) {
-		return lobby, nil
-	})); err != http.ErrServerClosed {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Logger().Error("failed to shut down", logging.Err(err))
+		}
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		panic(err)
 	}
+	// rooms are saved by now
+	<-stopped
 }
@@ -39,6 +39,10 @@ type EventProcessor interface {
 	Players() (r []int)
 	NewElement(e Element)
 	NewID() int
+	// Now is time of the current tick, elements should use it instead of time.Now, so simulation can be reproduced
+	Now() time.Time
+	// Rand is random source of the room, seeded the same way it gives the same numbers
+	Rand() *math.Rand
 }
 
 // TODO remove SetState, GetState, move it is to separate interface, use json-all by default
@@ -76,6 +80,33 @@ type Movable interface {
 	Move(duration time.Duration, processor EventProcessor) error // all changes in object
 }
 
+// Predictable player is moved by the client right after the input, without waiting for the server.
+// When server state arrives, inputs server has not processed yet are applied again on top of it
+type Predictable interface {
+	Playable
+	Movable
+	Predict() bool // false turns prediction off, e.g. when player can't move anyway
+}
+
+// Interpolatable elements of other players are drawn a bit in the past, smoothly moving between two
+// server states, instead of jumping to every new state. Interpolated are the fields to move smoothly
+type Interpolatable interface {
+	Element
+	Interpolated() []*math.Vector
+}
+
+// LagCompensator is an EventProcessor which remembers recent colliders, so hits of the player can be judged
+// by what he saw on his screen rather than by where things are right now. False means nothing is remembered
+// or there is no such element
+type LagCompensator interface {
+	ColliderSeenBy(player, id int) (math.Shape, bool)
+}
+
+// InterestArea is a part of the world player is interested in, only elements inside it are sent to him
+type InterestArea interface {
+	InterestArea() math.Box
+}
+
 type PreDraw interface {
 	PreDraw(c canvas.Canvas)
 }
@@ -50,6 +50,10 @@ func (s *Mob) Collide(other Collidable) error {
 	return nil
 }
 
+func (s *Mob) Interpolated() []*math.Vector {
+	return []*math.Vector{&s.Where.Corner}
+}
+
 func (s *Mob) Collider() math.Shape {
 	return s.Where
 }
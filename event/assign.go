@@ -0,0 +1,7 @@
+package event
+
+// Assign is payload of "assign" event: element of the client and the token to get it back after reconnection
+type Assign struct {
+	ID    int
+	Token string `json:",omitempty"`
+}
@@ -0,0 +1,33 @@
+package event
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const BatchType = "batch"
+
+// Batch is every event of one tick sent to a client in one frame
+type Batch struct {
+	Tick   uint64
+	Time   int64 // server time in milliseconds
+	Events []Event
+}
+
+func NewBatch(tick uint64, now time.Time, events []Event) (Event, error) {
+	data, err := json.Marshal(Batch{Tick: tick, Time: now.UnixNano() / int64(time.Millisecond), Events: events})
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Type: BatchType, Payload: data}, nil
+}
+
+// Unpack returns events of the batch, any other event is returned as is
+func Unpack(e Event) (Batch, error) {
+	if e.Type != BatchType {
+		return Batch{Events: []Event{e}}, nil
+	}
+	var b Batch
+	err := json.Unmarshal(e.Payload, &b)
+	return b, err
+}
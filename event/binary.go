@@ -0,0 +1,363 @@
+package event
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// known event types are sent as their index, new types should be appended to the end only
+var eventTypes = []string{"batch", "update", "patch", "add", "deleted", "delete", "input", "room", "assign", "game-over", "spectate", "queue"}
+
+var eventTypeIDs = func() map[string]uint64 {
+	r := map[string]uint64{}
+	for i, t := range eventTypes {
+		r[t] = uint64(i + 1)
+	}
+	return r
+}()
+
+var BadFrame = errors.New("bad binary frame")
+
+const (
+	flagPayload = 1 << iota
+	flagSeq
+	flagTime
+)
+
+// value tags of the payload encoding
+const (
+	tagNull = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagFloat32
+	tagFloat64
+	tagString
+	tagStringRef
+	tagArray
+	tagObject
+	tagEnd
+)
+
+// binaryCodec sends event as varints, payload JSON is transcoded to tagged values:
+// integers are varints, fractions are float32 if they are not too big, repeated strings (keys mostly) are
+// sent as references to the first occurrence in the same frame
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "gio.bin"
+}
+
+func (binaryCodec) Binary() bool {
+	return true
+}
+
+func (binaryCodec) Encode(e Event) ([]byte, error) {
+	w := &binaryWriter{strings: map[string]uint64{}}
+	var flags byte
+	if len(e.Payload) != 0 {
+		flags |= flagPayload
+	}
+	if e.Seq != 0 {
+		flags |= flagSeq
+	}
+	if e.Time != 0 {
+		flags |= flagTime
+	}
+	w.buf = append(w.buf, flags)
+	if id, ok := eventTypeIDs[e.Type]; ok {
+		w.uvarint(id)
+	} else {
+		w.uvarint(0)
+		w.uvarint(uint64(len(e.Type)))
+		w.buf = append(w.buf, e.Type...)
+	}
+	w.varint(int64(e.From))
+	if flags&flagSeq != 0 {
+		w.uvarint(uint64(e.Seq))
+	}
+	if flags&flagTime != 0 {
+		w.varint(e.Time)
+	}
+	if flags&flagPayload != 0 {
+		d := json.NewDecoder(bytes.NewReader(e.Payload))
+		d.UseNumber()
+		if err := w.value(d); err != nil {
+			return nil, fmt.Errorf("failed to encode payload of %s: %w", e.Type, err)
+		}
+	}
+	return w.buf, nil
+}
+
+func (binaryCodec) Decode(data []byte) (e Event, err error) {
+	r := &binaryReader{r: bytes.NewReader(data)}
+	flags, err := r.r.ReadByte()
+	if err != nil {
+		return e, BadFrame
+	}
+	id, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return e, BadFrame
+	}
+	switch {
+	case id == 0:
+		if e.Type, err = r.rawString(); err != nil {
+			return e, err
+		}
+	case id <= uint64(len(eventTypes)):
+		e.Type = eventTypes[id-1]
+	default:
+		return e, fmt.Errorf("unknown event type %d: %w", id, BadFrame)
+	}
+	from, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return e, BadFrame
+	}
+	e.From = int(from)
+	if flags&flagSeq != 0 {
+		seq, err := binary.ReadUvarint(r.r)
+		if err != nil {
+			return e, BadFrame
+		}
+		e.Seq = uint32(seq)
+	}
+	if flags&flagTime != 0 {
+		if e.Time, err = binary.ReadVarint(r.r); err != nil {
+			return e, BadFrame
+		}
+	}
+	if flags&flagPayload != 0 {
+		var out bytes.Buffer
+		if err = r.value(&out); err != nil {
+			return e, err
+		}
+		e.Payload = out.Bytes()
+	}
+	return e, nil
+}
+
+type binaryWriter struct {
+	buf     []byte
+	strings map[string]uint64
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (w *binaryWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func (w *binaryWriter) string(s string) {
+	if i, ok := w.strings[s]; ok {
+		w.buf = append(w.buf, tagStringRef)
+		w.uvarint(i)
+		return
+	}
+	w.strings[s] = uint64(len(w.strings))
+	w.buf = append(w.buf, tagString)
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *binaryWriter) value(d *json.Decoder) error {
+	t, err := d.Token()
+	if err != nil {
+		return err
+	}
+	switch v := t.(type) {
+	case nil:
+		w.buf = append(w.buf, tagNull)
+	case bool:
+		if v {
+			w.buf = append(w.buf, tagTrue)
+		} else {
+			w.buf = append(w.buf, tagFalse)
+		}
+	case string:
+		w.string(v)
+	case json.Number:
+		w.number(v)
+	case json.Delim:
+		switch v {
+		case '[':
+			w.buf = append(w.buf, tagArray)
+			for d.More() {
+				if err := w.value(d); err != nil {
+					return err
+				}
+			}
+		case '{':
+			w.buf = append(w.buf, tagObject)
+			for d.More() {
+				k, err := d.Token()
+				if err != nil {
+					return err
+				}
+				w.string(k.(string))
+				if err := w.value(d); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := d.Token(); err != nil {
+			return err
+		}
+		w.buf = append(w.buf, tagEnd)
+	}
+	return nil
+}
+
+func (w *binaryWriter) number(n json.Number) {
+	if i, err := n.Int64(); err == nil {
+		w.buf = append(w.buf, tagInt)
+		w.varint(i)
+		return
+	}
+	f, _ := n.Float64()
+	// float32 keeps positions and such precise enough while it can count in units
+	if math.Abs(f) < 1<<24 {
+		w.buf = append(w.buf, tagFloat32)
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(f)))
+		w.buf = append(w.buf, b[:]...)
+		return
+	}
+	w.buf = append(w.buf, tagFloat64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	w.buf = append(w.buf, b[:]...)
+}
+
+type binaryReader struct {
+	r       *bytes.Reader
+	strings []string
+}
+
+func (r *binaryReader) rawString() (string, error) {
+	l, err := binary.ReadUvarint(r.r)
+	if err != nil || l > uint64(r.r.Len()) {
+		return "", BadFrame
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return "", BadFrame
+	}
+	return string(b), nil
+}
+
+func (r *binaryReader) string(tag byte) (string, error) {
+	if tag == tagStringRef {
+		i, err := binary.ReadUvarint(r.r)
+		if err != nil || i >= uint64(len(r.strings)) {
+			return "", BadFrame
+		}
+		return r.strings[i], nil
+	}
+	s, err := r.rawString()
+	if err != nil {
+		return "", err
+	}
+	r.strings = append(r.strings, s)
+	return s, nil
+}
+
+// value reads one tagged value and writes it to out as JSON
+func (r *binaryReader) value(out *bytes.Buffer) error {
+	tag, err := r.r.ReadByte()
+	if err != nil {
+		return BadFrame
+	}
+	return r.tagged(tag, out)
+}
+
+func (r *binaryReader) tagged(tag byte, out *bytes.Buffer) error {
+	switch tag {
+	case tagNull:
+		out.WriteString("null")
+	case tagFalse:
+		out.WriteString("false")
+	case tagTrue:
+		out.WriteString("true")
+	case tagInt:
+		i, err := binary.ReadVarint(r.r)
+		if err != nil {
+			return BadFrame
+		}
+		out.WriteString(strconv.FormatInt(i, 10))
+	case tagFloat32:
+		var b [4]byte
+		if _, err := io.ReadFull(r.r, b[:]); err != nil {
+			return BadFrame
+		}
+		out.WriteString(strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b[:]))), 'g', -1, 32))
+	case tagFloat64:
+		var b [8]byte
+		if _, err := io.ReadFull(r.r, b[:]); err != nil {
+			return BadFrame
+		}
+		out.WriteString(strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b[:])), 'g', -1, 64))
+	case tagString, tagStringRef:
+		s, err := r.string(tag)
+		if err != nil {
+			return err
+		}
+		q, _ := json.Marshal(s)
+		out.Write(q)
+	case tagArray:
+		out.WriteByte('[')
+		for i := 0; ; i++ {
+			t, err := r.r.ReadByte()
+			if err != nil {
+				return BadFrame
+			}
+			if t == tagEnd {
+				break
+			}
+			if i != 0 {
+				out.WriteByte(',')
+			}
+			if err := r.tagged(t, out); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	case tagObject:
+		out.WriteByte('{')
+		for i := 0; ; i++ {
+			t, err := r.r.ReadByte()
+			if err != nil {
+				return BadFrame
+			}
+			if t == tagEnd {
+				break
+			}
+			if t != tagString && t != tagStringRef {
+				return fmt.Errorf("object key is not a string: %w", BadFrame)
+			}
+			if i != 0 {
+				out.WriteByte(',')
+			}
+			if err := r.tagged(t, out); err != nil {
+				return err
+			}
+			out.WriteByte(':')
+			if err := r.value(out); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	default:
+		return fmt.Errorf("unknown tag %d: %w", tag, BadFrame)
+	}
+	return nil
+}
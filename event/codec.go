@@ -0,0 +1,55 @@
+package event
+
+import "encoding/json"
+
+// Codec turns events into websocket frames and back, it is chosen by websocket subprotocol
+type Codec interface {
+	Name() string // websocket subprotocol of the codec
+	Binary() bool // frames should be sent as binary messages
+	Encode(e Event) ([]byte, error)
+	Decode(data []byte) (Event, error)
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	Compact Codec = binaryCodec{}
+)
+
+// Codecs are known codecs, the most preferred first
+var Codecs = []Codec{Compact, JSON}
+
+// Subprotocols are names of all known codecs for websocket negotiation
+func Subprotocols() (r []string) {
+	for _, c := range Codecs {
+		r = append(r, c.Name())
+	}
+	return
+}
+
+// CodecFor finds codec of the negotiated subprotocol, clients who negotiated nothing are talking JSON
+func CodecFor(subprotocol string) Codec {
+	for _, c := range Codecs {
+		if c.Name() == subprotocol {
+			return c
+		}
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "gio.json"
+}
+
+func (jsonCodec) Binary() bool {
+	return false
+}
+
+func (jsonCodec) Encode(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func (jsonCodec) Decode(data []byte) (Event, error) {
+	return ParseEvent(data)
+}
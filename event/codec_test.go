@@ -0,0 +1,84 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var codecEvents = []Event{
+	{Type: "input", From: 3, Seq: 17, Time: 1600000000123, Payload: json.RawMessage(`{"Keys":[true,false],"X":-12,"Y":0.5}`)},
+	{Type: "custom-type", From: -1},
+	{Type: "update", Payload: json.RawMessage(`[{"ID":1,"Pos":{"X":1.25,"Y":-300}},{"ID":2,"Pos":{"X":1e30,"Y":null}},"ID",""]`)},
+	{Type: "room", Payload: json.RawMessage(`"a string with \"quotes\" and юникод"`)},
+	{Type: "assign", Payload: json.RawMessage(`{"ID":9223372036854775807,"Token":""}`)},
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for _, c := range Codecs {
+		for _, e := range codecEvents {
+			data, err := c.Encode(e)
+			if err != nil {
+				t.Fatalf("%s: failed to encode %+v: %v", c.Name(), e, err)
+			}
+			got, err := c.Decode(data)
+			if err != nil {
+				t.Fatalf("%s: failed to decode %+v: %v", c.Name(), e, err)
+			}
+			// payload is compared by TestCodecsKeepPayload, binary one may be written differently
+			got.Payload, e.Payload = nil, nil
+			if !reflect.DeepEqual(got, e) {
+				t.Errorf("%s: got %+v, want %+v", c.Name(), got, e)
+			}
+		}
+	}
+}
+
+func TestCodecsKeepPayload(t *testing.T) {
+	for _, c := range Codecs {
+		for _, e := range codecEvents {
+			if len(e.Payload) == 0 {
+				continue
+			}
+			data, err := c.Encode(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, got.Payload, e.Payload) {
+				t.Errorf("%s: payload %s, want %s", c.Name(), got.Payload, e.Payload)
+			}
+		}
+	}
+}
+
+func TestBinaryIsCompact(t *testing.T) {
+	e := Event{Type: "update", Payload: json.RawMessage(`[{"ID":1,"X":100},{"ID":2,"X":200},{"ID":3,"X":300}]`)}
+	bin, _ := Compact.Encode(e)
+	js, _ := JSON.Encode(e)
+	if len(bin)*2 > len(js) {
+		t.Errorf("binary frame is %d bytes, JSON one is %d", len(bin), len(js))
+	}
+}
+
+func TestBinaryRejectsBadFrames(t *testing.T) {
+	good, err := Compact.Encode(codecEvents[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{nil, {0}, {0, 200}, good[:len(good)-3]} {
+		if _, err := Compact.Decode(data); !errors.Is(err, BadFrame) {
+			t.Errorf("frame %v is decoded: %v", data, err)
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	if CodecFor("gio.bin") != Compact || CodecFor("") != JSON || CodecFor("unknown") != JSON {
+		t.Fatal("wrong codec is chosen")
+	}
+}
@@ -6,6 +6,8 @@ type Event struct {
 	Type    string
 	From    int
 	Payload json.RawMessage
+	Seq     uint32 `json:",omitempty"` // input number, server returns the last processed one with the player's state
+	Time    int64  `json:",omitempty"` // server time in milliseconds client was showing when he made the input
 }
 
 func ParseEvent(raw []byte) (e Event, err error) {
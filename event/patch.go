@@ -0,0 +1,113 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// Diff makes JSON merge patch (RFC 7386) turning old document into the new one.
+// Merge patch can't set value to null, so ok is false when new document has nulls where old one has not,
+// full document should be sent in this case
+func Diff(old, new []byte) (patch []byte, ok bool, err error) {
+	var o, n interface{}
+	if err = json.Unmarshal(old, &o); err != nil {
+		return nil, false, err
+	}
+	if err = json.Unmarshal(new, &n); err != nil {
+		return nil, false, err
+	}
+	om, oIsObj := o.(map[string]interface{})
+	nm, nIsObj := n.(map[string]interface{})
+	if !oIsObj || !nIsObj {
+		return nil, false, nil
+	}
+	d, ok := diffObjects(om, nm)
+	if !ok {
+		return nil, false, nil
+	}
+	patch, err = json.Marshal(d)
+	return patch, err == nil, err
+}
+
+func diffObjects(old, new map[string]interface{}) (map[string]interface{}, bool) {
+	d := map[string]interface{}{}
+	for k, nv := range new {
+		ov, exists := old[k]
+		if exists && reflect.DeepEqual(ov, nv) {
+			continue
+		}
+		if nv == nil {
+			return nil, false
+		}
+		om, oIsObj := ov.(map[string]interface{})
+		nm, nIsObj := nv.(map[string]interface{})
+		if exists && oIsObj && nIsObj {
+			sub, ok := diffObjects(om, nm)
+			if !ok {
+				return nil, false
+			}
+			d[k] = sub
+			continue
+		}
+		if nIsObj && hasNull(nm) {
+			return nil, false
+		}
+		d[k] = nv
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			d[k] = nil
+		}
+	}
+	return d, true
+}
+
+// hasNull reports objects which would lose their null fields when used as a patch
+func hasNull(obj map[string]interface{}) bool {
+	for _, v := range obj {
+		if v == nil {
+			return true
+		}
+		if m, ok := v.(map[string]interface{}); ok && hasNull(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEmptyPatch reports patch which changes nothing
+func IsEmptyPatch(patch []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(patch), []byte("{}"))
+}
+
+// Patch applies JSON merge patch (RFC 7386) to the document
+func Patch(doc, patch []byte) ([]byte, error) {
+	var d, p interface{}
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(d, p))
+}
+
+func mergePatch(doc, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	dm, ok := doc.(map[string]interface{})
+	if !ok {
+		dm = map[string]interface{}{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(dm, k)
+			continue
+		}
+		dm[k] = mergePatch(dm[k], v)
+	}
+	return dm
+}
@@ -0,0 +1,71 @@
+package event
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// sameJSON reports documents with the same values, order of the keys doesn't matter
+func sameJSON(t *testing.T, a, b []byte) bool {
+	var av, bv interface{}
+	if err := json.Unmarshal(a, &av); err != nil {
+		t.Fatalf("%s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		t.Fatalf("%s: %v", b, err)
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func TestDiffPatchRoundTrip(t *testing.T) {
+	cases := []struct{ old, new string }{
+		{`{"X":1,"Y":2}`, `{"X":1,"Y":3}`},
+		{`{"Pos":{"X":1,"Y":2},"HP":10}`, `{"Pos":{"X":1,"Y":5},"HP":10}`},
+		{`{"A":1,"B":2}`, `{"A":1}`},
+		{`{"A":[1,2]}`, `{"A":[2],"B":{"C":"d"}}`},
+		{`{"A":1}`, `{"A":1}`},
+	}
+	for _, c := range cases {
+		patch, ok, err := Diff([]byte(c.old), []byte(c.new))
+		if err != nil || !ok {
+			t.Fatalf("no diff of %s and %s: %v", c.old, c.new, err)
+		}
+		got, err := Patch([]byte(c.old), patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameJSON(t, got, []byte(c.new)) {
+			t.Errorf("%s patched by %s is %s, want %s", c.old, patch, got, c.new)
+		}
+	}
+}
+
+func TestDiffOfTheSameIsEmpty(t *testing.T) {
+	patch, ok, err := Diff([]byte(`{"A":{"B":1}}`), []byte(`{"A":{"B":1}}`))
+	if err != nil || !ok || !IsEmptyPatch(patch) {
+		t.Fatalf("patch %s of the same documents", patch)
+	}
+}
+
+func TestDiffCantSetNull(t *testing.T) {
+	for _, c := range []struct{ old, new string }{
+		{`{"A":1}`, `{"A":null}`},
+		{`{}`, `{"A":{"B":null}}`},
+		{`[1]`, `[2]`},
+	} {
+		if _, ok, err := Diff([]byte(c.old), []byte(c.new)); ok || err != nil {
+			t.Errorf("%s to %s has a merge patch: %v", c.old, c.new, err)
+		}
+	}
+}
+
+func TestPatchDeletesNullFields(t *testing.T) {
+	got, err := Patch([]byte(`{"A":1,"B":{"C":2,"D":3}}`), []byte(`{"A":null,"B":{"D":null,"E":4}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"B":{"C":2,"E":4}}`; !sameJSON(t, got, []byte(want)) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
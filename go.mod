@@ -2,4 +2,7 @@ module github.com/arovesto/gio
 
 go 1.16
 
-require nhooyr.io/websocket v1.8.6
+require (
+	github.com/BurntSushi/toml v1.2.1
+	nhooyr.io/websocket v1.8.6
+)
@@ -0,0 +1,240 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how important the message is, messages below the level of the logger are skipped
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// DefaultRepeatInterval is how often the same repeated message is written
+const DefaultRepeatInterval = 5 * time.Second
+
+var levelNames = map[Level]string{DebugLevel: "debug", InfoLevel: "info", WarnLevel: "warn", ErrorLevel: "error"}
+
+func (l Level) String() string {
+	if n, ok := levelNames[l]; ok {
+		return n
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel reads level as it is written in the config: debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return l, nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// Field is a key-value pair written after the message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// fields logged by the server, so they are named the same way everywhere
+
+func RoomKey(key string) Field       { return F("room", key) }
+func RoomType(tp string) Field       { return F("room_type", tp) }
+func Player(id int) Field            { return F("player", id) }
+func Element(id int) Field           { return F("element", id) }
+func ElementType(tp int) Field       { return F("element_type", tp) }
+func Tick(tick uint64) Field         { return F("tick", tick) }
+func Err(err error) Field            { return F("err", err) }
+func Duration(d time.Duration) Field { return F("took", d) }
+
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// Repeated is for messages which may be logged every tick, such as overloads: the same message is
+	// written once in a while with the number of skipped ones
+	Repeated(level Level, msg string, fields ...Field)
+	// With makes logger which adds fields to every message
+	With(fields ...Field) Logger
+}
+
+// repeat counts the skipped copies of the repeated message
+type repeat struct {
+	last    time.Time
+	skipped int
+}
+
+// core is shared by the logger and every logger made by its With
+type core struct {
+	out      *log.Logger
+	level    Level
+	interval time.Duration
+
+	lock    sync.Mutex
+	repeats map[string]*repeat
+}
+
+type logger struct {
+	*core
+	fields []Field
+	scope  string // fields as they are written, repeated messages of different scopes are counted apart
+}
+
+// staleRepeats is how many repeated messages are kept before the ones not written for a while are forgotten
+const staleRepeats = 256
+
+// New makes logger which writes messages of the level and above to w as
+// "2006/01/02 15:04:05 WARN message key=value ...", repeated ones at most once per DefaultRepeatInterval
+func New(w io.Writer, level Level) Logger {
+	return NewWithInterval(w, level, DefaultRepeatInterval)
+}
+
+// NewWithInterval is New which writes repeated messages at most once per interval
+func NewWithInterval(w io.Writer, level Level, interval time.Duration) Logger {
+	return &logger{core: &core{
+		out:      log.New(w, "", log.LstdFlags),
+		level:    level,
+		interval: interval,
+		repeats:  map[string]*repeat{},
+	}}
+}
+
+var std = New(os.Stderr, InfoLevel)
+
+// Default writes info and above to stderr, it is used by everything which is not given own logger
+func Default() Logger {
+	return std
+}
+
+// Discard skips every message
+func Discard() Logger {
+	return New(io.Discard, ErrorLevel+1)
+}
+
+func (l *logger) Debug(msg string, fields ...Field) { l.write(DebugLevel, msg, 0, fields) }
+func (l *logger) Info(msg string, fields ...Field)  { l.write(InfoLevel, msg, 0, fields) }
+func (l *logger) Warn(msg string, fields ...Field)  { l.write(WarnLevel, msg, 0, fields) }
+func (l *logger) Error(msg string, fields ...Field) { l.write(ErrorLevel, msg, 0, fields) }
+
+func (l *logger) Repeated(level Level, msg string, fields ...Field) {
+	if level < l.level {
+		return
+	}
+	now := time.Now()
+	key := msg + l.scope
+	l.lock.Lock()
+	r, ok := l.repeats[key]
+	if !ok {
+		if len(l.repeats) >= staleRepeats {
+			l.forget(now)
+		}
+		r = &repeat{}
+		l.repeats[key] = r
+	}
+	if now.Sub(r.last) < l.interval {
+		r.skipped++
+		l.lock.Unlock()
+		return
+	}
+	skipped := r.skipped
+	r.last, r.skipped = now, 0
+	l.lock.Unlock()
+	l.write(level, msg, skipped, fields)
+}
+
+// At is With for fields which change from message to message, such as the tick: they are written after
+// the bound ones, but repeated messages are counted regardless of them
+func At(l Logger, fields ...Field) Logger {
+	return &atLogger{Logger: l, fields: fields}
+}
+
+type atLogger struct {
+	Logger
+	fields []Field
+}
+
+func (a *atLogger) Debug(msg string, fields ...Field) { a.Logger.Debug(msg, a.with(fields)...) }
+func (a *atLogger) Info(msg string, fields ...Field)  { a.Logger.Info(msg, a.with(fields)...) }
+func (a *atLogger) Warn(msg string, fields ...Field)  { a.Logger.Warn(msg, a.with(fields)...) }
+func (a *atLogger) Error(msg string, fields ...Field) { a.Logger.Error(msg, a.with(fields)...) }
+
+func (a *atLogger) Repeated(level Level, msg string, fields ...Field) {
+	a.Logger.Repeated(level, msg, a.with(fields)...)
+}
+
+func (a *atLogger) With(fields ...Field) Logger {
+	return &atLogger{Logger: a.Logger.With(fields...), fields: a.fields}
+}
+
+func (a *atLogger) with(fields []Field) []Field {
+	all := make([]Field, 0, len(a.fields)+len(fields))
+	return append(append(all, a.fields...), fields...)
+}
+
+// forget deletes repeated messages which have nothing skipped and may be written again, e.g. of destroyed rooms
+func (c *core) forget(now time.Time) {
+	for k, r := range c.repeats {
+		if r.skipped == 0 && now.Sub(r.last) >= c.interval {
+			delete(c.repeats, k)
+		}
+	}
+}
+
+func (l *logger) With(fields ...Field) Logger {
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(append(all, l.fields...), fields...)
+	var b strings.Builder
+	for _, f := range all {
+		writeField(&b, f)
+	}
+	return &logger{core: l.core, fields: all, scope: b.String()}
+}
+
+func (l *logger) write(level Level, msg string, skipped int, fields []Field) {
+	if level < l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, f := range l.fields {
+		writeField(&b, f)
+	}
+	for _, f := range fields {
+		writeField(&b, f)
+	}
+	if skipped > 0 {
+		writeField(&b, F("skipped", skipped))
+	}
+	l.out.Print(b.String())
+}
+
+func writeField(b *strings.Builder, f Field) {
+	b.WriteByte(' ')
+	b.WriteString(f.Key)
+	b.WriteByte('=')
+	v := fmt.Sprint(f.Value)
+	if v == "" || strings.ContainsAny(v, " =\"") {
+		v = strconv.Quote(v)
+	}
+	b.WriteString(v)
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lines(buf *bytes.Buffer) []string {
+	return strings.Split(strings.TrimSpace(buf.String()), "\n")
+}
+
+func TestLevelSkipsMessages(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, WarnLevel)
+	l.Info("skipped")
+	l.Warn("written", RoomKey("lobby"), F("name", "two words"))
+	got := lines(&buf)
+	if len(got) != 1 || !strings.HasSuffix(got[0], `WARN written room=lobby name="two words"`) {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+func TestRepeatedIsWrittenOnceInAWhile(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewWithInterval(&buf, InfoLevel, time.Hour)
+	for i := 0; i < 5; i++ {
+		l.Repeated(WarnLevel, "overloaded")
+	}
+	if got := lines(&buf); len(got) != 1 {
+		t.Fatalf("repeated message is written %d times", len(got))
+	}
+}
+
+func TestRepeatedOfRoomsAreCountedApart(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewWithInterval(&buf, InfoLevel, time.Hour)
+	a, b := l.With(RoomKey("a")), l.With(RoomKey("b"))
+	for i := 0; i < 3; i++ {
+		a.Repeated(WarnLevel, "overloaded")
+		b.Repeated(WarnLevel, "overloaded")
+	}
+	got := lines(&buf)
+	if len(got) != 2 || !strings.Contains(got[0], "room=a") || !strings.Contains(got[1], "room=b") {
+		t.Fatalf("each room should write its message once, got %q", got)
+	}
+}
+
+func TestRepeatedReportsSkipped(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewWithInterval(&buf, InfoLevel, 20*time.Millisecond)
+	l.Repeated(WarnLevel, "overloaded")
+	l.Repeated(WarnLevel, "overloaded")
+	l.Repeated(WarnLevel, "overloaded")
+	time.Sleep(30 * time.Millisecond)
+	l.Repeated(WarnLevel, "overloaded")
+	got := lines(&buf)
+	if len(got) != 2 || !strings.HasSuffix(got[1], "skipped=2") {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+func TestRepeatedIgnoresFieldsOfAt(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewWithInterval(&buf, InfoLevel, time.Hour).With(RoomKey("a"))
+	for tick := uint64(0); tick < 5; tick++ {
+		At(l, Tick(tick)).Repeated(WarnLevel, "overloaded")
+	}
+	got := lines(&buf)
+	if len(got) != 1 || !strings.HasSuffix(got[0], "WARN overloaded room=a tick=0") {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
@@ -0,0 +1,65 @@
+package math
+
+import "math/rand"
+
+// Rand is a source of random numbers of its own, the same seed gives the same numbers.
+// Elements should use the one of their room, so simulation can be reproduced. It is not safe for concurrent use
+type Rand struct {
+	r    *rand.Rand
+	src  *source
+	seed int64
+}
+
+// source counts numbers it made, so another one can be put to the same place
+type source struct {
+	rand.Source64
+	drawn uint64
+}
+
+func (s *source) Int63() int64 {
+	s.drawn++
+	return s.Source64.Int63()
+}
+
+func (s *source) Uint64() uint64 {
+	s.drawn++
+	return s.Source64.Uint64()
+}
+
+func NewRand(seed int64) *Rand {
+	return NewRandAt(seed, 0)
+}
+
+// NewRandAt is Rand of the seed which has already made drawn numbers, e.g. to go on with the recorded simulation
+func NewRandAt(seed int64, drawn uint64) *Rand {
+	src := &source{Source64: rand.NewSource(seed).(rand.Source64)}
+	for src.drawn < drawn {
+		src.Uint64()
+	}
+	return &Rand{r: rand.New(src), src: src, seed: seed}
+}
+
+func (r *Rand) Random(min, max int) int {
+	return r.r.Intn(max-min) + min
+}
+
+func (r *Rand) RandomF(min, max float64) float64 {
+	return min + r.r.Float64()*(max-min)
+}
+
+func (r *Rand) RandomInBox(b Box) Vector {
+	return Vector{
+		X: r.RandomF(b.Corner.X, b.Corner.X+b.Size.X),
+		Y: r.RandomF(b.Corner.Y, b.Corner.Y+b.Size.Y),
+	}
+}
+
+// Seed is the seed Rand was made with
+func (r *Rand) Seed() int64 {
+	return r.seed
+}
+
+// Drawn is how many numbers the source has made, see NewRandAt
+func (r *Rand) Drawn() uint64 {
+	return r.src.drawn
+}
@@ -0,0 +1,36 @@
+package math
+
+import "testing"
+
+func draw(r *Rand, n int) []int {
+	res := make([]int, n)
+	for i := range res {
+		res[i] = r.Random(0, 1<<30)
+		r.RandomF(0, 1)
+	}
+	return res
+}
+
+func TestRandIsReproducible(t *testing.T) {
+	a, b := draw(NewRand(42), 100), draw(NewRand(42), 100)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("number %d differs: %d and %d", i, a[i], b[i])
+		}
+	}
+}
+
+func TestRandAtContinues(t *testing.T) {
+	r := NewRand(7)
+	draw(r, 50)
+	if r.Seed() != 7 {
+		t.Fatalf("seed is %d, want 7", r.Seed())
+	}
+	at := NewRandAt(r.Seed(), r.Drawn())
+	a, b := draw(r, 50), draw(at, 50)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("number %d differs: %d and %d", i, a[i], b[i])
+		}
+	}
+}
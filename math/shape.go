@@ -51,6 +51,11 @@ func (v Vector) Len() float64 {
 	return math.Sqrt(v.X*v.X + v.Y*v.Y)
 }
 
+// Lerp is a point between a and b, t = 0 is a and t = 1 is b
+func Lerp(a, b Vector, t float64) Vector {
+	return a.Add(b.Sub(a).Mul(t))
+}
+
 func SquaredEuclideanDistance(a, b Vector) float64 {
 	s := a.Sub(b)
 	return s.X*s.X + s.Y*s.Y
@@ -127,6 +132,45 @@ func CenterOf(s Shape) Vector {
 
 type Shape interface{}
 
+// CopyOf is a copy of the shape which doesn't share memory with it
+func CopyOf(s Shape) Shape {
+	if sVal, ok := s.([]Sphere); ok {
+		return append([]Sphere(nil), sVal...)
+	}
+	return s
+}
+
+// BoundsOf is the smallest box containing the shape
+func BoundsOf(s Shape) Box {
+	switch sVal := s.(type) {
+	case Box:
+		return sVal
+	case Sphere:
+		return Box{Corner: sVal.Center.Sub(Vector{X: sVal.R, Y: sVal.R}), Size: Vector{X: 2 * sVal.R, Y: 2 * sVal.R}}
+	case Ellipse:
+		return Box{Corner: sVal.Center.Sub(sVal.Radius), Size: sVal.Radius.Mul(2)}
+	case []Sphere:
+		if len(sVal) == 0 {
+			return Box{}
+		}
+		r := BoundsOf(sVal[0])
+		for _, o := range sVal[1:] {
+			r = r.Union(BoundsOf(o))
+		}
+		return r
+	default:
+		log.Panicln("not implemented BoundsOf", sVal)
+		return Box{}
+	}
+}
+
+// Union is the smallest box containing both boxes
+func (b Box) Union(o Box) Box {
+	min := Vector{X: math.Min(b.Corner.X, o.Corner.X), Y: math.Min(b.Corner.Y, o.Corner.Y)}
+	max := Vector{X: math.Max(b.Corner.X+b.Size.X, o.Corner.X+o.Size.X), Y: math.Max(b.Corner.Y+b.Size.Y, o.Corner.Y+o.Size.Y)}
+	return Box{Corner: min, Size: max.Sub(min)}
+}
+
 func BoxCollide(a, b Box) bool {
 	aTop, aBottom, aLeft, aRight := a.Corner.Y, a.Corner.Y+a.Size.Y, a.Corner.X, a.Corner.X+a.Size.X
 	bTop, bBottom, bLeft, bRight := b.Corner.Y, b.Corner.Y+b.Size.Y, b.Corner.X, b.Corner.X+b.Size.X
@@ -247,6 +291,8 @@ func AngleBetween(from, to Vector) float64 {
 	return math.Atan2(from.X*to.Y-from.Y*to.X, from.X*to.X+from.Y*to.Y)
 }
 
+// Random, RandomF and RandomInBox use the global source, simulation can't be reproduced with them, see Rand
+
 func Random(min, max int) int {
 	return rand.Intn(max-min) + min
 }
@@ -0,0 +1,383 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are upper bounds of histogram buckets in seconds, they fit durations of a tick
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .016, .025, .05, .1, .25}
+
+type kind int
+
+const (
+	counterKind kind = iota
+	gaugeKind
+	histogramKind
+)
+
+var kindNames = map[kind]string{counterKind: "counter", gaugeKind: "gauge", histogramKind: "histogram"}
+
+// Registry keeps metrics in memory and writes them in Prometheus text format,
+// tests may read values right from it by Value
+type Registry struct {
+	lock     sync.RWMutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// family is a metric with every set of its label values
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	lock   sync.RWMutex
+	series map[string]*series // by joined label values
+}
+
+type series struct {
+	value  float64bits // counter and gauge, first for atomic alignment
+	values []string
+	hist   *Histogram
+}
+
+// float64bits is a float64 changed atomically
+type float64bits struct {
+	bits uint64
+}
+
+func (f *float64bits) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *float64bits) store(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *float64bits) add(d float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		if atomic.CompareAndSwapUint64(&f.bits, old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+// family returns the metric of the name, it is made if there is no such one.
+// Metric of the same name with another kind or labels is a programming error
+func (r *Registry) family(name, help string, k kind, buckets []float64, labels []string) *family {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metric %s is already registered as %s with labels %v", name, kindNames[f.kind], f.labels))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: k, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", f.name, f.labels, values))
+	}
+	key := strings.Join(values, "\xff")
+	f.lock.RLock()
+	s, ok := f.series[key]
+	f.lock.RUnlock()
+	if ok {
+		return s
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if s, ok = f.series[key]; ok {
+		return s
+	}
+	s = &series{values: append([]string(nil), values...)}
+	if f.kind == histogramKind {
+		s.hist = &Histogram{bounds: f.buckets, counts: make([]uint64, len(f.buckets))}
+	}
+	f.series[key] = s
+	return s
+}
+
+// CounterVec is a counter with labels
+type CounterVec struct{ f *family }
+
+// GaugeVec is a gauge with labels
+type GaugeVec struct{ f *family }
+
+// HistogramVec is a histogram with labels
+type HistogramVec struct{ f *family }
+
+// Counter is a value which only grows
+type Counter struct{ s *series }
+
+// Gauge is a value which may go up and down
+type Gauge struct{ s *series }
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	count  uint64 // first for atomic alignment
+	sum    float64bits
+	bounds []float64
+	counts []uint64 // not cumulative, +Inf is count minus their sum
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.family(name, help, counterKind, nil, labels)}
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.family(name, help, gaugeKind, nil, labels)}
+}
+
+// Histogram makes histogram with buckets upper bounds, DefBuckets if there are none
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{r.family(name, help, histogramKind, buckets, labels)}
+}
+
+// With is the counter of the label values, given in the order of labels
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{v.f.with(values)}
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{v.f.with(values)}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.f.with(values).hist
+}
+
+// Nil counters, gauges and histograms do nothing, so code without metrics needs no checks
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds d, it must not be negative
+func (c *Counter) Add(d float64) {
+	if c == nil || d < 0 {
+		return
+	}
+	c.s.value.add(d)
+}
+
+func (c *Counter) Value() float64 {
+	if c == nil {
+		return 0
+	}
+	return c.s.value.load()
+}
+
+func (g *Gauge) Set(v float64) {
+	if g == nil {
+		return
+	}
+	g.s.value.store(v)
+}
+
+func (g *Gauge) Add(d float64) {
+	if g == nil {
+		return
+	}
+	g.s.value.add(d)
+}
+
+func (g *Gauge) Value() float64 {
+	if g == nil {
+		return 0
+	}
+	return g.s.value.load()
+}
+
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	h.sum.add(v)
+	atomic.AddUint64(&h.count, 1)
+}
+
+func (h *Histogram) Count() uint64 {
+	if h == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&h.count)
+}
+
+func (h *Histogram) Sum() float64 {
+	if h == nil {
+		return 0
+	}
+	return h.sum.load()
+}
+
+// Value is the value of the counter or gauge with the label values, for histogram it is the number of observations.
+// False if there is no such metric
+func (r *Registry) Value(name string, values ...string) (float64, bool) {
+	r.lock.RLock()
+	f, ok := r.families[name]
+	r.lock.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	f.lock.RLock()
+	s, ok := f.series[strings.Join(values, "\xff")]
+	f.lock.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	if s.hist != nil {
+		return float64(s.hist.Count()), true
+	}
+	return s.value.load(), true
+}
+
+// Delete removes every series with the label value, e.g. metrics of the destroyed room
+func (r *Registry) Delete(label, value string) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, f := range r.families {
+		i := indexOf(f.labels, label)
+		if i < 0 {
+			continue
+		}
+		f.lock.Lock()
+		for k, s := range f.series {
+			if s.values[i] == value {
+				delete(f.series, k)
+			}
+		}
+		f.lock.Unlock()
+	}
+}
+
+func indexOf(labels []string, label string) int {
+	for i, l := range labels {
+		if l == label {
+			return i
+		}
+	}
+	return -1
+}
+
+// WriteText writes every metric in Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.lock.RLock()
+	fs := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		fs = append(fs, f)
+	}
+	r.lock.RUnlock()
+	sort.Slice(fs, func(i, j int) bool { return fs[i].name < fs[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range fs {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.lock.RLock()
+	ss := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		ss = append(ss, s)
+	}
+	f.lock.RUnlock()
+	sort.Slice(ss, func(i, j int) bool {
+		return strings.Join(ss[i].values, "\xff") < strings.Join(ss[j].values, "\xff")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, kindNames[f.kind])
+	for _, s := range ss {
+		labels := f.labelPairs(s.values)
+		if s.hist == nil {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, braces(labels), formatFloat(s.value.load()))
+			continue
+		}
+		var cumulative uint64
+		for i, b := range s.hist.bounds {
+			cumulative += atomic.LoadUint64(&s.hist.counts[i])
+			le := append(labels[:len(labels):len(labels)], `le="`+formatFloat(b)+`"`)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, braces(le), cumulative)
+		}
+		count := s.hist.Count()
+		le := append(labels[:len(labels):len(labels)], `le="+Inf"`)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, braces(le), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, braces(labels), formatFloat(s.hist.Sum()))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, braces(labels), count)
+	}
+}
+
+func (f *family) labelPairs(values []string) []string {
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = f.labels[i] + `="` + escapeValue(v) + `"`
+	}
+	return pairs
+}
+
+func braces(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeValue(v string) string {
+	return valueEscaper.Replace(v)
+}
+
+func escapeHelp(h string) string {
+	return helpEscaper.Replace(h)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeHTTP serves the metrics to Prometheus
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WriteText(w)
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCountersAndGauges(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("events_total", "Events.", "room")
+	c.With("lobby").Inc()
+	c.With("lobby").Add(2)
+	c.With("lobby").Add(-5) // counters don't go down
+	r.Gauge("players", "Players.").With().Set(4)
+	r.Gauge("players", "Players.").With().Add(-1)
+
+	if v, ok := r.Value("events_total", "lobby"); !ok || v != 3 {
+		t.Errorf("counter is %v %v, want 3", v, ok)
+	}
+	if v, ok := r.Value("players"); !ok || v != 3 {
+		t.Errorf("gauge is %v %v, want 3", v, ok)
+	}
+	if _, ok := r.Value("events_total", "game"); ok {
+		t.Error("counter of unused labels exists")
+	}
+}
+
+func TestNilMetricsDoNothing(t *testing.T) {
+	var c *Counter
+	var g *Gauge
+	var h *Histogram
+	c.Inc()
+	g.Set(1)
+	h.Observe(1)
+	if c.Value() != 0 || g.Value() != 0 || h.Count() != 0 {
+		t.Fatal("nil metrics have values")
+	}
+}
+
+func TestConcurrentAdds(t *testing.T) {
+	c := NewRegistry().Counter("adds_total", "Adds.").With()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Add(0.5)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Value() != 4000 {
+		t.Fatalf("counter is %v, want 4000", c.Value())
+	}
+}
+
+func TestDeleteRemovesSeriesOfTheLabel(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("ticks_total", "Ticks.", "room").With("a").Inc()
+	r.Counter("ticks_total", "Ticks.", "room").With("b").Inc()
+	r.Gauge("clients", "Clients.", "room").With("a").Set(1)
+	r.Delete("room", "a")
+	if _, ok := r.Value("ticks_total", "a"); ok {
+		t.Error("counter of the deleted room exists")
+	}
+	if _, ok := r.Value("clients", "a"); ok {
+		t.Error("gauge of the deleted room exists")
+	}
+	if _, ok := r.Value("ticks_total", "b"); !ok {
+		t.Error("counter of another room is deleted")
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("events_total", "Events\nof rooms.", "room").With(`a"b`).Add(2)
+	h := r.Histogram("tick_seconds", "Tick duration.", []float64{0.1, 0.01}, "room").With("lobby")
+	h.Observe(0.005)
+	h.Observe(0.05)
+	h.Observe(1)
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		`# HELP events_total Events\nof rooms.`,
+		`# TYPE events_total counter`,
+		`events_total{room="a\"b"} 2`,
+		`# HELP tick_seconds Tick duration.`,
+		`# TYPE tick_seconds histogram`,
+		`tick_seconds_bucket{room="lobby",le="0.01"} 1`,
+		`tick_seconds_bucket{room="lobby",le="0.1"} 2`,
+		`tick_seconds_bucket{room="lobby",le="+Inf"} 3`,
+		`tick_seconds_sum{room="lobby"} 1.055`,
+		`tick_seconds_count{room="lobby"} 3`,
+	}, "\n") + "\n"
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestRegisteringAnotherKindPanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("x", "X.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("gauge of the counter name is registered")
+		}
+	}()
+	r.Gauge("x", "X.")
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/logging"
+)
+
+const adminPrefix = "/admin/"
+
+var (
+	ElementExists  = errors.New("element already exists")
+	UnknownElement = errors.New("unknown element type")
+)
+
+var stateNames = map[int]string{Created: "created", Running: "running", Draining: "draining", Stopped: "stopped", Web: "web"}
+
+// RoomInfo is a room in the list of the admin API
+type RoomInfo struct {
+	Key        string
+	Type       string
+	State      string
+	Players    int
+	Clients    int
+	Spectators int
+	MinPlayers int
+	MaxPlayers int
+	Tick       uint64
+}
+
+// ElementInfo is an element in the list of the admin API
+type ElementInfo struct {
+	ID    int
+	Type  int
+	State json.RawMessage
+}
+
+// admin is the HTTP API to look into the rooms and manage them, every room is read and changed by its own goroutine:
+//
+//	GET    /admin/rooms                              rooms with their states and players
+//	GET    /admin/rooms/{key}                        state of the room as clients get it
+//	POST   /admin/rooms/{key}/stop                   stops the room
+//	GET    /admin/rooms/{key}/elements?type=N        elements of the room, only of type N if it is set
+//	POST   /admin/rooms/{key}/elements               spawns element {"type": N, "data": {...}}, data without ID gets a new one
+//	DELETE /admin/rooms/{key}/elements/{id}          deletes the element
+//	POST   /admin/rooms/{key}/players/{id}/kick      disconnects the player, he can't resume
+//	POST   /admin/rooms/{key}/players/{id}/transfer?to={key}
+//
+// Requests must have "Authorization: Bearer <token>" header
+type admin struct {
+	token []byte
+	rooms *Registry
+	log   logging.Logger
+}
+
+func newAdmin(token string, rooms *Registry, log logging.Logger) *admin {
+	return &admin{token: []byte(token), rooms: rooms, log: log}
+}
+
+func (a *admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, adminPrefix), "/"), "/")
+	if path[0] != "rooms" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if len(path) == 1 {
+		a.route(w, r, http.MethodGet, func() (interface{}, error) { return a.list(), nil })
+		return
+	}
+	room, ok := a.rooms.Lookup(path[1])
+	if !ok {
+		writeError(w, http.StatusNotFound, RoomNotFound)
+		return
+	}
+	switch {
+	case len(path) == 2:
+		a.route(w, r, http.MethodGet, func() (interface{}, error) { return a.state(room) })
+	case len(path) == 3 && path[2] == "stop":
+		a.route(w, r, http.MethodPost, func() (interface{}, error) { return nil, a.stop(room) })
+	case len(path) == 3 && path[2] == "elements" && r.Method == http.MethodPost:
+		a.route(w, r, http.MethodPost, func() (interface{}, error) { return a.spawn(room, r) })
+	case len(path) == 3 && path[2] == "elements":
+		a.route(w, r, http.MethodGet, func() (interface{}, error) { return a.elements(room, r.URL.Query().Get("type")) })
+	case len(path) == 4 && path[2] == "elements":
+		a.route(w, r, http.MethodDelete, func() (interface{}, error) { return nil, a.deleteElement(room, path[3]) })
+	case len(path) == 5 && path[2] == "players" && path[4] == "kick":
+		a.route(w, r, http.MethodPost, func() (interface{}, error) { return nil, a.kick(room, path[3]) })
+	case len(path) == 5 && path[2] == "players" && path[4] == "transfer":
+		a.route(w, r, http.MethodPost, func() (interface{}, error) { return nil, a.transfer(room, path[3], r.URL.Query().Get("to")) })
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (a *admin) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return len(a.token) != 0 && subtle.ConstantTimeCompare([]byte(token), a.token) == 1
+}
+
+// route calls handler if request has the method and writes what it returned
+func (a *admin) route(w http.ResponseWriter, r *http.Request, method string, handler func() (interface{}, error)) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	res, err := handler()
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		a.log.Info("admin request is done", logging.F("method", r.Method), logging.F("path", r.URL.Path))
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if raw, ok := res.(json.RawMessage); ok {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(raw)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// badRequest is an error of the request itself
+type badRequest struct {
+	error
+}
+
+func statusOf(err error) int {
+	var br badRequest
+	switch {
+	case errors.As(err, &br):
+		return http.StatusBadRequest
+	case errors.Is(err, RoomNotFound), errors.Is(err, EntityNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, RoomStopped), errors.Is(err, ElementExists), errors.Is(err, PlayerBusy):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func (a *admin) list() []RoomInfo {
+	rooms := a.rooms.Rooms()
+	res := make([]RoomInfo, 0, len(rooms))
+	for key, room := range rooms {
+		info := RoomInfo{Key: key, Type: room.Type}
+		// stopped rooms have nothing else to tell
+		_ = room.Do(func(r *Room) {
+			info.Players = r.PlayersCount()
+			info.Clients = len(r.clients)
+			info.Spectators = r.Spectators()
+			info.MinPlayers, info.MaxPlayers = r.Capacity()
+			info.Tick = r.tick
+		})
+		info.State = stateNames[room.CurrentState()]
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+func (a *admin) state(room *Room) (res json.RawMessage, err error) {
+	doErr := room.Do(func(r *Room) {
+		res, err = r.GetState()
+	})
+	if doErr != nil {
+		return nil, doErr
+	}
+	return res, err
+}
+
+func (a *admin) stop(room *Room) error {
+	if st := room.CurrentState(); st == Stopped || st == Draining {
+		return RoomStopped
+	}
+	room.StopWithReason("room is stopped by admin")
+	return nil
+}
+
+func (a *admin) elements(room *Room, tp string) (res []ElementInfo, err error) {
+	filter, typed := 0, tp != ""
+	if typed {
+		if filter, err = strconv.Atoi(tp); err != nil {
+			return nil, badRequest{fmt.Errorf("bad element type %q", tp)}
+		}
+	}
+	res = []ElementInfo{}
+	doErr := room.Do(func(r *Room) {
+		for id, e := range r.elements {
+			if typed && e.GetType() != filter {
+				continue
+			}
+			st, e2 := e.GetState()
+			if e2 != nil {
+				err = e2
+				return
+			}
+			res = append(res, ElementInfo{ID: id, Type: e.GetType(), State: st})
+		}
+	})
+	if doErr != nil {
+		return nil, doErr
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, err
+}
+
+// spawn makes element of the type from the request body, elements which keep id in the "ID" field get a new one
+// if it is not set
+func (a *admin) spawn(room *Room, r *http.Request) (res *ElementInfo, err error) {
+	var raw RawElement
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, badRequest{fmt.Errorf("bad element: %w", err)}
+	}
+	gen, ok := elements.GenElements[raw.Type]
+	if !ok {
+		return nil, badRequest{fmt.Errorf("%w %d", UnknownElement, raw.Type)}
+	}
+	fields := map[string]json.RawMessage{}
+	if len(raw.Data) != 0 {
+		if err := json.Unmarshal(raw.Data, &fields); err != nil {
+			return nil, badRequest{fmt.Errorf("bad element data: %w", err)}
+		}
+	}
+	doErr := room.Do(func(r *Room) {
+		if _, ok := fields["ID"]; !ok {
+			fields["ID"], _ = json.Marshal(r.NewID())
+		}
+		data, e2 := json.Marshal(fields)
+		if e2 != nil {
+			err = e2
+			return
+		}
+		el := gen()
+		if e2 := el.SetState(data); e2 != nil {
+			err = badRequest{fmt.Errorf("bad element data: %w", e2)}
+			return
+		}
+		if _, ok := r.elements[el.GetID()]; ok {
+			err = fmt.Errorf("%w: %d", ElementExists, el.GetID())
+			return
+		}
+		r.NewElement(el)
+		st, e2 := el.GetState()
+		if e2 != nil {
+			err = e2
+			return
+		}
+		res = &ElementInfo{ID: el.GetID(), Type: el.GetType(), State: st}
+	})
+	if doErr != nil {
+		return nil, doErr
+	}
+	return res, err
+}
+
+func (a *admin) deleteElement(room *Room, idStr string) (err error) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return badRequest{fmt.Errorf("bad element id %q", idStr)}
+	}
+	doErr := room.Do(func(r *Room) {
+		if _, ok := r.elements[id]; !ok {
+			err = EntityNotFound
+			return
+		}
+		if _, ok := r.clients[id]; ok || r.isAway(id) {
+			// the player is disconnected, otherwise he would stay in the room without element,
+			// away one would come back to nothing
+			err = r.Kick(id, "element is deleted by admin")
+			return
+		}
+		r.DeleteElement(id)
+	})
+	if doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+func (a *admin) kick(room *Room, idStr string) (err error) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return badRequest{fmt.Errorf("bad player id %q", idStr)}
+	}
+	doErr := room.Do(func(r *Room) {
+		err = r.Kick(id, "kicked by admin")
+	})
+	if doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+func (a *admin) transfer(room *Room, idStr, to string) (err error) {
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return badRequest{fmt.Errorf("bad player id %q", idStr)}
+	}
+	target, ok := a.rooms.Lookup(to)
+	if !ok {
+		return RoomNotFound
+	}
+	if target == room {
+		return badRequest{errors.New("player is in this room already")}
+	}
+	doErr := room.Do(func(r *Room) {
+		err = r.Transfer(id, target)
+	})
+	if doErr != nil {
+		return doErr
+	}
+	return err
+}
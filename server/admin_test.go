@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/arovesto/gio/logging"
+)
+
+const adminToken = "secret"
+
+// adminOf serves admin API of the registry with the room registered as "lobby"
+func adminOf(t *testing.T, room *Room) func(method, path string) int {
+	reg := NewRegistry(time.Minute)
+	t.Cleanup(reg.Close)
+	if err := reg.Register("lobby", room); err != nil {
+		t.Fatal(err)
+	}
+	a := newAdmin(adminToken, reg, logging.Discard())
+	return func(method, path string) int {
+		req := httptest.NewRequest(method, adminPrefix+path, nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, req)
+		return w.Code
+	}
+}
+
+func TestAdminNeedsToken(t *testing.T) {
+	a := newAdmin(adminToken, NewRegistry(time.Minute), logging.Discard())
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, httptest.NewRequest(http.MethodGet, adminPrefix+"rooms", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("request without token is %d", w.Code)
+	}
+}
+
+func TestAdminDeletesElementOfAwayPlayer(t *testing.T) {
+	room := lobbyRoom()
+	call := adminOf(t, room)
+	var id int
+	_ = room.Do(func(r *Room) {
+		id = r.NewID()
+		r.NewElement(&counter{ID: id})
+		r.away[id] = &awayPlayer{until: time.Now().Add(time.Hour)}
+	})
+	path := "rooms/lobby/elements/" + strconv.Itoa(id)
+	if code := call(http.MethodDelete, path); code != http.StatusNoContent {
+		t.Fatalf("delete is %d", code)
+	}
+	_ = room.Do(func(r *Room) {
+		if _, ok := r.away[id]; ok {
+			t.Error("away player is kept without element")
+		}
+		if _, ok := r.elements[id]; ok {
+			t.Error("element is not deleted")
+		}
+	})
+	if code := call(http.MethodDelete, path); code != http.StatusNotFound {
+		t.Fatalf("delete of the missing element is %d", code)
+	}
+}
+
+func TestTransferOfBusyPlayerFails(t *testing.T) {
+	room := lobbyRoom()
+	id := room.NewID()
+	room.NewElement(&counter{ID: id})
+	// nobody takes the transfer
+	room.clients[id] = &player{session: &session{transfer: make(chan *Room)}}
+	err := room.Transfer(id, lobbyRoom())
+	if !errors.Is(err, PlayerBusy) {
+		t.Fatalf("transfer of the busy player is %v", err)
+	}
+	if statusOf(err) != http.StatusConflict {
+		t.Fatalf("busy player is %d", statusOf(err))
+	}
+	if _, ok := room.elements[id]; !ok {
+		t.Fatal("element of the busy player is deleted")
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
+)
+
+// broadcastState sends changed state of the element to every client, as a merge patch against
+// the last state this client got or as a full update if client has nothing to patch
+func (s *Room) broadcastState(id int, state []byte) {
+	patches := map[string][]byte{} // clients usually share the same base, so diff it once
+	for pid, p := range s.clients {
+		if !p.sees(id) {
+			continue
+		}
+		base, ok := p.known[id]
+		p.known[id] = state
+		if ok && bytes.Equal(base, state) {
+			continue
+		}
+		var seq uint32
+		if pid == id {
+			seq = p.ack // owner needs to know which of his inputs are in this state
+		}
+		if !ok {
+			p.pending = append(p.pending, event.Event{Type: "update", From: id, Payload: state, Seq: seq})
+			continue
+		}
+		patch, cached := patches[string(base)]
+		if !cached {
+			d, ok, err := event.Diff(base, state)
+			if err != nil {
+				s.logAt(s.elements[id]).Error("failed to diff state", logging.Err(err))
+			}
+			if ok {
+				patch = d
+			}
+			patches[string(base)] = patch
+		}
+		if patch == nil {
+			p.pending = append(p.pending, event.Event{Type: "update", From: id, Payload: state, Seq: seq})
+		} else if !event.IsEmptyPatch(patch) {
+			p.pending = append(p.pending, event.Event{Type: "patch", From: id, Payload: patch, Seq: seq})
+		}
+	}
+}
+
+// resync sends full states of everything movable to clients who lost some updates
+func (s *Room) resync() {
+	for id, p := range s.clients {
+		dropped := p.session.out.Stats().Dropped
+		if dropped == p.dropped {
+			continue
+		}
+		p.dropped = dropped
+		p.known = map[int][]byte{}
+		for _, e := range s.movable {
+			if !p.sees(e.GetID()) {
+				continue
+			}
+			state, err := e.GetState()
+			if err != nil {
+				s.logAt(e).Error("failed to get state for resync", logging.Player(id), logging.Err(err))
+				continue
+			}
+			var seq uint32
+			if e.GetID() == id {
+				seq = p.ack
+			}
+			p.known[e.GetID()] = state
+			p.pending = append(p.pending, event.Event{Type: "update", From: e.GetID(), Payload: state, Seq: seq})
+		}
+	}
+}
+
+// remember stores states client got with the room snapshot
+func (s *Room) remember(p *player) {
+	p.known = map[int][]byte{}
+	for _, e := range s.movable {
+		if !p.sees(e.GetID()) {
+			continue
+		}
+		state, err := e.GetState()
+		if err != nil {
+			continue
+		}
+		p.known[e.GetID()] = state
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arovesto/gio/event"
+)
+
+// slowClient is a client of the element id in the room whose send queue holds one message and is never written
+func slowClient(room *Room, id int) *player {
+	ss := &session{
+		codec:    event.JSON,
+		out:      newOutbound(1, DropUpdates, 0, false),
+		in:       newInbox(0),
+		transfer: make(chan *Room, 1),
+	}
+	p := &player{session: ss}
+	room.remember(p)
+	room.clients[id] = p
+	return p
+}
+
+// sent are events of the batches waiting in the queue of the client, the queue is emptied
+func sent(t *testing.T, p *player) (res []event.Event) {
+	for _, m := range p.session.out.pop() {
+		e, err := event.ParseEvent(m.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := event.Unpack(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, b.Events...)
+	}
+	return
+}
+
+func TestDroppedPatchIsFollowedByFullState(t *testing.T) {
+	room := lobbyRoom()
+	id := room.NewID()
+	c := &counter{ID: id}
+	room.NewElement(c)
+	p := slowClient(room, id)
+
+	room.Step(room.TickDuration())
+	got := sent(t, p)
+	if len(got) != 1 || got[0].Type != "patch" || got[0].From != id {
+		t.Fatalf("first tick sent %+v, want a patch", got)
+	}
+	state, _ := c.GetState()
+	if !bytes.Equal(p.known[id], state) {
+		t.Fatalf("client knows %s, want %s", p.known[id], state)
+	}
+
+	// the second tick fills the queue, the third one is dropped
+	room.Step(room.TickDuration())
+	room.Step(room.TickDuration())
+	if st := p.session.out.Stats(); st.Dropped != 1 {
+		t.Fatalf("%d messages are dropped, want 1", st.Dropped)
+	}
+	sent(t, p)
+
+	room.Step(room.TickDuration())
+	got = sent(t, p)
+	state, _ = c.GetState()
+	if len(got) != 1 || got[0].Type != "update" || !bytes.Equal(got[0].Payload, state) {
+		t.Fatalf("tick after the drop sent %+v, want the full state %s", got, state)
+	}
+}
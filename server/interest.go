@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
+	"github.com/arovesto/gio/math"
+)
+
+// WithInterestManagement makes room send every player only elements near him: inside the view box centered
+// on his collider or inside his InterestArea. Elements without collider are sent to everyone
+func WithInterestManagement(view math.Vector) RoomOption {
+	return func(r *Room) {
+		r.view = view
+	}
+}
+
+func (s *Room) interestManaged() bool {
+	return s.view.X > 0 && s.view.Y > 0
+}
+
+// interestOf is an area player id is interested in, false means he is interested in everything
+func (s *Room) interestOf(id int) (math.Box, bool) {
+	el := s.elements[id]
+	if ia, ok := el.(elements.InterestArea); ok {
+		return ia.InterestArea(), true
+	}
+	if c, ok := el.(elements.Collidable); ok {
+		return math.Box{Corner: math.CenterOf(math.BoundsOf(c.Collider())).Sub(s.view.Mul(0.5)), Size: s.view}, true
+	}
+	return math.Box{}, false
+}
+
+// interesting finds elements player id should know about
+func (s *Room) interesting(id int) map[int]struct{} {
+	area, limited := s.interestOf(id)
+	r := map[int]struct{}{}
+	for elID, el := range s.elements {
+		c, ok := el.(elements.Collidable)
+		if elID == id || !limited || !ok || math.BoxCollide(area, math.BoundsOf(c.Collider())) {
+			r[elID] = struct{}{}
+		}
+	}
+	return r
+}
+
+// sees reports does client know about the element
+func (p *player) sees(id int) bool {
+	if p.visible == nil {
+		return true
+	}
+	_, ok := p.visible[id]
+	return ok
+}
+
+// updateInterest sends "add" for elements which came into players' areas and "deleted" for the ones which left
+func (s *Room) updateInterest() {
+	if !s.interestManaged() {
+		return
+	}
+	for id, p := range s.clients {
+		now := s.interesting(id)
+		for elID := range p.visible {
+			if _, ok := now[elID]; !ok {
+				delete(p.visible, elID)
+				delete(p.known, elID)
+				p.pending = append(p.pending, event.Event{Type: "deleted", From: elID})
+			}
+		}
+		for elID := range now {
+			if _, ok := p.visible[elID]; ok {
+				continue
+			}
+			el := s.elements[elID]
+			st, err := el.GetState()
+			if err != nil {
+				s.logAt(el).Error("failed to get state", logging.Player(id), logging.Err(err))
+				continue
+			}
+			p.visible[elID] = struct{}{}
+			if _, ok := el.(elements.Movable); ok {
+				p.known[elID] = st
+			}
+			p.pending = append(p.pending, event.Event{Type: "add", From: el.GetType(), Payload: st})
+		}
+	}
+}
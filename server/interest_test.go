@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/math"
+)
+
+const dotType = 90002
+
+// dot is a unit box which moves by its speed every tick
+type dot struct {
+	ID    int
+	Pos   math.Vector
+	Speed math.Vector
+}
+
+func (d *dot) GetID() int                 { return d.ID }
+func (d *dot) GetType() int               { return dotType }
+func (d *dot) GetState() ([]byte, error)  { return json.Marshal(d) }
+func (d *dot) SetState(data []byte) error { return json.Unmarshal(data, d) }
+func (d *dot) Collide(elements.Collidable) error {
+	return nil
+}
+func (d *dot) Collider() math.Shape {
+	return math.Box{Corner: d.Pos, Size: math.Vector{X: 1, Y: 1}}
+}
+func (d *dot) Move(time.Duration, elements.EventProcessor) error {
+	d.Pos = d.Pos.Add(d.Speed)
+	return nil
+}
+
+func init() {
+	elements.GenElements[dotType] = func() elements.Element { return &dot{} }
+}
+
+func TestElementIsShownWhenItComesIntoView(t *testing.T) {
+	room := NewBasicRoom(0, "field", nil, WithInterestManagement(math.Vector{X: 20, Y: 20}))
+	me := &dot{ID: room.NewID()}
+	room.NewElement(me)
+	other := &dot{ID: room.NewID(), Pos: math.Vector{X: 13}, Speed: math.Vector{X: -1}}
+	room.NewElement(other)
+	p := slowClient(room, me.ID)
+	p.visible = room.interesting(me.ID)
+
+	if !p.sees(me.ID) || p.sees(other.ID) {
+		t.Fatalf("client sees %v, want only his own element", p.visible)
+	}
+	room.Step(room.TickDuration())
+	for _, e := range sent(t, p) {
+		if e.From == other.ID {
+			t.Fatalf("element outside the view is sent: %+v", e)
+		}
+	}
+
+	// the view ends at 10.5, the dot reaches it on the third tick
+	room.Step(room.TickDuration())
+	sent(t, p)
+	room.Step(room.TickDuration())
+	got := sent(t, p)
+	if !p.sees(other.ID) || len(got) != 1 || got[0].Type != "add" || got[0].From != dotType {
+		t.Fatalf("element in the view is sent as %+v, want it added", got)
+	}
+	var added dot
+	if err := json.Unmarshal(got[0].Payload, &added); err != nil || added != *other {
+		t.Fatalf("added %s, want %+v", got[0].Payload, *other)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"time"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/math"
+)
+
+const DefaultMaxRewind = 200 * time.Millisecond
+
+type colliderFrame struct {
+	at     time.Time
+	shapes map[int]math.Shape
+}
+
+// WithLagCompensation makes room remember colliders of the last maxRewind, so hits can be judged by what the
+// player saw when he made his input. Players who lag more than maxRewind are judged by the oldest colliders
+func WithLagCompensation(maxRewind time.Duration) RoomOption {
+	return func(r *Room) {
+		if maxRewind <= 0 {
+			maxRewind = DefaultMaxRewind
+		}
+		r.maxRewind = maxRewind
+	}
+}
+
+// recordColliders remembers colliders of the tick and forgets the ones nobody can rewind to anymore
+func (s *Room) recordColliders(now time.Time) {
+	if s.maxRewind <= 0 {
+		return
+	}
+	shapes := make(map[int]math.Shape, len(s.collidable))
+	for id, c := range s.collidable {
+		shapes[id] = math.CopyOf(c.Collider())
+	}
+	s.history = append(s.history, colliderFrame{at: now, shapes: shapes})
+	// one frame older than the window is kept, it is where the oldest allowed moment is
+	i := 0
+	for i+1 < len(s.history) && now.Sub(s.history[i+1].at) >= s.maxRewind {
+		i++
+	}
+	s.history = s.history[i:]
+}
+
+// ColliderAt is collider of element id as it was at the moment, clamped to the rewind window.
+// Elements which did not exist back then have their current collider
+func (s *Room) ColliderAt(id int, at time.Time) (math.Shape, bool) {
+	if len(s.history) == 0 {
+		return nil, false
+	}
+	latest := s.history[len(s.history)-1].at
+	if oldest := latest.Add(-s.maxRewind); at.Before(oldest) {
+		at = oldest
+	}
+	i := len(s.history) - 1
+	for i > 0 && s.history[i].at.After(at) {
+		i--
+	}
+	if shape, ok := s.history[i].shapes[id]; ok {
+		return shape, true
+	}
+	c, ok := s.elements[id].(elements.Collidable)
+	if !ok {
+		return nil, false
+	}
+	return c.Collider(), true
+}
+
+// ColliderSeenBy is collider of element id as player saw it when he made his last input
+func (s *Room) ColliderSeenBy(player, id int) (math.Shape, bool) {
+	at, ok := s.seen[player]
+	if !ok {
+		at = s.Now()
+	}
+	return s.ColliderAt(id, at)
+}
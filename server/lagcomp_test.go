@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arovesto/gio/math"
+)
+
+func TestColliderAt(t *testing.T) {
+	start := time.Unix(0, 0)
+	room := NewBasicRoom(0, "field", nil, WithTickRate(100), WithClock(start), WithLagCompensation(50*time.Millisecond))
+	d := &dot{ID: room.NewID(), Speed: math.Vector{X: 1}}
+	room.NewElement(d)
+	if _, ok := room.ColliderAt(d.ID, start); ok {
+		t.Fatal("collider is found before the first tick")
+	}
+	// the dot is at X = tick after every tick
+	for i := 0; i < 10; i++ {
+		room.Step(room.TickDuration())
+	}
+	late := &dot{ID: room.NewID(), Pos: math.Vector{Y: 5}}
+	room.NewElement(late)
+
+	tick := func(n float64) time.Time { return start.Add(time.Duration(n * float64(10*time.Millisecond))) }
+	for _, c := range []struct {
+		name string
+		id   int
+		at   time.Time
+		want math.Vector
+	}{
+		{"latest", d.ID, tick(10), math.Vector{X: 10}},
+		{"in the future", d.ID, tick(12), math.Vector{X: 10}},
+		{"tick of the history", d.ID, tick(7), math.Vector{X: 7}},
+		{"between ticks", d.ID, tick(7.5), math.Vector{X: 7}},
+		{"oldest allowed", d.ID, tick(5), math.Vector{X: 5}},
+		{"older than the window", d.ID, tick(1), math.Vector{X: 5}},
+		{"not in the history", late.ID, tick(7), math.Vector{Y: 5}},
+	} {
+		shape, ok := room.ColliderAt(c.id, c.at)
+		if !ok {
+			t.Errorf("%s: collider is not found", c.name)
+			continue
+		}
+		if got := math.BoundsOf(shape).Corner; got != c.want {
+			t.Errorf("%s: collider is at %v, want %v", c.name, got, c.want)
+		}
+	}
+	if _, ok := room.ColliderAt(room.NewID(), tick(7)); ok {
+		t.Error("collider of unknown element is found")
+	}
+}
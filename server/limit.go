@@ -0,0 +1,151 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/arovesto/gio/event"
+)
+
+// LimitAction says what to do with the frame over the client's rate limit
+type LimitAction int
+
+const (
+	// DropExcess drops frames over the limit
+	DropExcess LimitAction = iota
+	// CountOffense drops frames over the limit and counts them as bad events, see WithMaxOffenses
+	CountOffense
+	// DisconnectFlooder closes connection on the first frame over the limit
+	DisconnectFlooder
+)
+
+// RateLimit limits what one client may send, zero fields are not limited
+type RateLimit struct {
+	Messages     float64 // frames per second
+	MessageBurst int
+	Bytes        float64 // bytes per second
+	ByteBurst    int
+	MaxFrame     int64 // bigger frames close the connection
+	Action       LimitAction
+}
+
+// DefaultRateLimit lets clients send input every tick with some room for jitter, it limits connections served by
+// Room.Run and Room.Watch without server
+var DefaultRateLimit = RateLimit{
+	Messages:     2 * DefaultTickRate,
+	MessageBurst: 4 * DefaultTickRate,
+	Bytes:        64 << 10,
+	ByteBurst:    128 << 10,
+	MaxFrame:     16 << 10,
+	Action:       DropExcess,
+}
+
+// DefaultInboxSize is how many events of one client may wait for the room
+const DefaultInboxSize = 32
+
+// bucket is a token bucket, it gets rate tokens per second up to burst
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, burst int) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(rate)
+	}
+	return &bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// take reports are there n tokens and takes them, nil bucket has them always
+func (b *bucket) take(n float64, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// limiter is the rate limit of one connection
+type limiter struct {
+	messages *bucket
+	bytes    *bucket
+	action   LimitAction
+}
+
+func newLimiter(l RateLimit) limiter {
+	return limiter{
+		messages: newBucket(l.Messages, l.MessageBurst),
+		bytes:    newBucket(l.Bytes, l.ByteBurst),
+		action:   l.Action,
+	}
+}
+
+func (l limiter) allow(size int) bool {
+	now := time.Now()
+	// both buckets are checked, so frame over the byte limit still costs a message
+	m := l.messages.take(1, now)
+	b := l.bytes.take(float64(size), now)
+	return m && b
+}
+
+// inbox is a bounded queue of events of one client, the room takes events from every inbox in turn,
+// so one chatty client can't fill the tick with his events only
+type inbox struct {
+	lock   sync.Mutex
+	events []event.Event
+	size   int
+}
+
+func newInbox(size int) *inbox {
+	if size <= 0 {
+		size = DefaultInboxSize
+	}
+	return &inbox{size: size}
+}
+
+// push is false if the inbox is full and the event is dropped
+func (q *inbox) push(e event.Event) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.events) >= q.size {
+		return false
+	}
+	q.events = append(q.events, e)
+	return true
+}
+
+func (q *inbox) pop() (e event.Event, ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.events) == 0 {
+		return e, false
+	}
+	e = q.events[0]
+	q.events = q.events[1:]
+	return e, true
+}
+
+func (q *inbox) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.events)
+}
+
+func (q *inbox) clear() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.events = nil
+}
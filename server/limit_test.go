@@ -0,0 +1,81 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arovesto/gio/event"
+)
+
+func TestBucketRefillsUpToBurst(t *testing.T) {
+	now := time.Now()
+	b := newBucket(10, 3)
+	b.last = now
+	for i := 0; i < 3; i++ {
+		if !b.take(1, now) {
+			t.Fatalf("token %d of the burst is not taken", i)
+		}
+	}
+	if b.take(1, now) {
+		t.Fatal("token over the burst is taken")
+	}
+	if !b.take(1, now.Add(100*time.Millisecond)) {
+		t.Fatal("bucket is not refilled")
+	}
+	// a long pause gives the burst, not more
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !b.take(1, later) {
+			t.Fatalf("token %d after the pause is not taken", i)
+		}
+	}
+	if b.take(1, later) {
+		t.Fatal("bucket is refilled over the burst")
+	}
+}
+
+func TestNilBucketIsNotLimited(t *testing.T) {
+	b := newBucket(0, 10)
+	if b != nil {
+		t.Fatal("zero rate makes a bucket")
+	}
+	if !b.take(1e9, time.Now()) {
+		t.Fatal("nil bucket limits")
+	}
+}
+
+func TestLimiterCountsFramesOverByteLimit(t *testing.T) {
+	l := newLimiter(RateLimit{Messages: 1, MessageBurst: 2, Bytes: 1, ByteBurst: 100})
+	if l.allow(1000) {
+		t.Fatal("frame over the byte burst is allowed")
+	}
+	if !l.allow(10) {
+		t.Fatal("the second message of the burst is not allowed")
+	}
+	if l.allow(10) {
+		t.Fatal("message over the burst is allowed, the big frame should have cost one")
+	}
+}
+
+func TestInboxDropsOverSize(t *testing.T) {
+	q := newInbox(2)
+	for i := 0; i < 2; i++ {
+		if !q.push(eventOf(i)) {
+			t.Fatalf("event %d is dropped", i)
+		}
+	}
+	if q.push(eventOf(2)) {
+		t.Fatal("event over the size is queued")
+	}
+	if e, ok := q.pop(); !ok || e.From != 0 {
+		t.Fatalf("got %+v, want the first event", e)
+	}
+	q.clear()
+	if q.len() != 0 {
+		t.Fatal("inbox is not cleared")
+	}
+}
+
+func eventOf(from int) event.Event {
+	return event.Event{Type: "input", From: from}
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
+)
+
+const (
+	QueueEventType = "queue"
+
+	// how often queues are matched and players are told their positions
+	matchInterval = time.Second
+)
+
+var (
+	PoolNotFound     = errors.New("pool not found")
+	MatchmakerClosed = errors.New("matchmaker is closed")
+)
+
+// Pool is a kind of game matchmaker makes rooms for
+type Pool struct {
+	MinPlayers int
+	MaxPlayers int
+	// once there are MinPlayers in the queue, how long to wait for more before the room is started
+	Wait time.Duration
+	// NewRoom makes an instance of the game, its join policy should let MaxPlayers join
+	NewRoom func() *Room
+}
+
+// QueueStatus is payload of the "queue" event, sent to every waiting player each matchInterval
+type QueueStatus struct {
+	Pool     string
+	Position int   // 1 is the next one
+	ETA      int64 // estimated wait in milliseconds
+}
+
+type ticket struct {
+	room  *Room // where player waits
+	id    int
+	since time.Time
+}
+
+type pool struct {
+	Pool
+	name      string
+	queue     []ticket
+	instances map[*Room]int // players sent to the instance who may have not joined yet
+	made      int
+	avgWait   time.Duration // of the recently matched players, it is how ETA is estimated
+}
+
+// move is a player who is matched and has to be transferred
+type move struct {
+	ticket
+	to *Room
+}
+
+// Matchmaker keeps queues of players waiting for games. When there are enough players in the queue
+// the room is made, registered as temporary and players are transferred there. Rooms which are not full
+// get players from the queue first, so new instances are made only when the existing ones are full
+type Matchmaker struct {
+	lock     sync.Mutex
+	registry *Registry
+	pools    map[string]*pool
+	signal   chan struct{}
+	done     chan struct{}
+	stopped  chan struct{} // loop is over
+	once     sync.Once
+}
+
+func NewMatchmaker(registry *Registry) *Matchmaker {
+	m := &Matchmaker{
+		registry: registry,
+		pools:    map[string]*pool{},
+		signal:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go m.loop()
+	return m
+}
+
+// AddPool adds kind of game players can queue for
+func (m *Matchmaker) AddPool(name string, p Pool) error {
+	if p.NewRoom == nil {
+		return fmt.Errorf("pool %s can't make rooms", name)
+	}
+	if p.MinPlayers <= 0 {
+		p.MinPlayers = 1
+	}
+	if p.MaxPlayers < p.MinPlayers {
+		p.MaxPlayers = p.MinPlayers
+	}
+	if p.Wait < 0 {
+		p.Wait = 0
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.pools[name]; ok {
+		return RoomExists
+	}
+	m.pools[name] = &pool{Pool: p, name: name, instances: map[*Room]int{}, avgWait: p.Wait}
+	return nil
+}
+
+// Enqueue puts player id of the room r to the queue of the pool, he stays in r until he is matched.
+// It doesn't wait for the room, so it may be called by room goroutine
+func (m *Matchmaker) Enqueue(name string, r *Room, id int) error {
+	m.lock.Lock()
+	p, ok := m.pools[name]
+	if !ok {
+		m.lock.Unlock()
+		return PoolNotFound
+	}
+	for _, t := range p.queue {
+		if t.room == r && t.id == id {
+			m.lock.Unlock()
+			return nil
+		}
+	}
+	p.queue = append(p.queue, ticket{room: r, id: id, since: time.Now()})
+	m.lock.Unlock()
+	m.poke()
+	return nil
+}
+
+// Dequeue removes player id of the room r from every queue
+func (m *Matchmaker) Dequeue(r *Room, id int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, p := range m.pools {
+		p.remove(func(t ticket) bool { return t.room == r && t.id == id })
+	}
+}
+
+// Close stops matching and waits for the matching in progress, queued players stay where they are.
+// No rooms are made after it
+func (m *Matchmaker) Close() {
+	m.once.Do(func() {
+		close(m.done)
+	})
+	<-m.stopped
+}
+
+func (m *Matchmaker) poke() {
+	select {
+	case m.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Matchmaker) loop() {
+	defer close(m.stopped)
+	ticker := time.NewTicker(matchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.match(true)
+		case <-m.signal:
+			m.match(false)
+		}
+	}
+}
+
+// match transfers players of every pool to the rooms, notify tells the rest their positions
+func (m *Matchmaker) match(notify bool) {
+	var moves []move
+	var positions map[ticket]QueueStatus
+	m.lock.Lock()
+	for _, p := range m.pools {
+		if notify {
+			// players sent a tick ago have joined already
+			for r := range p.instances {
+				p.instances[r] = 0
+			}
+		}
+		moves = append(moves, m.matchPool(p)...)
+	}
+	if notify {
+		positions = m.statuses()
+	}
+	m.lock.Unlock()
+
+	for _, mv := range moves {
+		mv := mv
+		err := mv.room.Do(func(r *Room) {
+			if err := r.Transfer(mv.id, mv.to); err != nil {
+				r.Logger().Warn("failed to transfer matched player", logging.Player(mv.id), logging.F("to", mv.to.Key()), logging.Err(err))
+			}
+		})
+		if err != nil && !errors.Is(err, RoomStopped) {
+			mv.room.Logger().Warn("failed to transfer matched player", logging.Player(mv.id), logging.F("to", mv.to.Key()), logging.Err(err))
+		}
+	}
+	for t, st := range positions {
+		t, st := t, st
+		payload, err := json.Marshal(st)
+		if err != nil {
+			m.registry.log.Error("failed to make queue status", logging.F("pool", st.Pool), logging.Err(err))
+			continue
+		}
+		_ = t.room.Do(func(r *Room) {
+			_ = r.SendEvent(t.id, event.Event{Type: QueueEventType, From: t.id, Payload: payload})
+		})
+	}
+}
+
+// statuses are positions and ETAs of the waiting players, must be called under lock
+func (m *Matchmaker) statuses() map[ticket]QueueStatus {
+	res := map[ticket]QueueStatus{}
+	now := time.Now()
+	for _, p := range m.pools {
+		for i, t := range p.queue {
+			// players ahead of him would fill that many rooms before his turn
+			rounds := time.Duration(i/p.MaxPlayers + 1)
+			eta := p.avgWait*rounds - now.Sub(t.since)
+			if eta < 0 {
+				eta = 0
+			}
+			res[t] = QueueStatus{Pool: p.name, Position: i + 1, ETA: eta.Milliseconds()}
+		}
+	}
+	return res
+}
+
+// matchPool fills the rooms of the pool with players from the queue, must be called under lock
+func (m *Matchmaker) matchPool(p *pool) (moves []move) {
+	// players who left the room they waited in are not waiting anymore
+	p.remove(func(t ticket) bool {
+		return !t.room.hasPlayer(t.id)
+	})
+	for r, sent := range p.instances {
+		if _, ok := m.registry.Lookup(r.Key()); !ok || r.CurrentState() == Stopped || r.CurrentState() == Draining {
+			delete(p.instances, r)
+			continue
+		}
+		for free := p.MaxPlayers - r.PlayersCount() - sent; free > 0 && len(p.queue) > 0; free-- {
+			moves = append(moves, p.take(r))
+		}
+	}
+	for len(p.queue) >= p.MaxPlayers || len(p.queue) >= p.MinPlayers && time.Since(p.queue[0].since) >= p.Wait {
+		r, err := m.instance(p)
+		if err != nil {
+			m.registry.log.Error("failed to make room", logging.F("pool", p.name), logging.Err(err))
+			return
+		}
+		for i := 0; i < p.MaxPlayers && len(p.queue) > 0; i++ {
+			moves = append(moves, p.take(r))
+		}
+	}
+	return
+}
+
+// instance makes a new room of the pool, must be called under lock
+func (m *Matchmaker) instance(p *pool) (*Room, error) {
+	select {
+	case <-m.done:
+		return nil, fmt.Errorf("pool %s: %w", p.name, MatchmakerClosed)
+	default:
+	}
+	p.made++
+	r := p.NewRoom()
+	if r == nil {
+		return nil, fmt.Errorf("pool %s made no room", p.name)
+	}
+	r.minPlayers, r.maxPlayers = p.MinPlayers, p.MaxPlayers
+	if err := m.registry.RegisterTemporary(fmt.Sprintf("%s-%d", p.name, p.made), r); err != nil {
+		return nil, err
+	}
+	p.instances[r] = 0
+	return r, nil
+}
+
+// overflow finds a room for the player who can't join r as it is full
+func (m *Matchmaker) overflow(r *Room) (*Room, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, p := range m.pools {
+		if _, ok := p.instances[r]; !ok {
+			continue
+		}
+		for other, sent := range p.instances {
+			if other != r && other.CurrentState() != Stopped && other.CurrentState() != Draining && other.PlayersCount()+sent < p.MaxPlayers {
+				p.instances[other]++
+				return other, nil
+			}
+		}
+		other, err := m.instance(p)
+		if err == nil {
+			p.instances[other]++
+		}
+		return other, err
+	}
+	return nil, RoomFull
+}
+
+// take moves the first player of the queue to room r
+func (p *pool) take(r *Room) move {
+	t := p.queue[0]
+	p.queue = p.queue[1:]
+	p.instances[r]++
+	p.avgWait += (time.Since(t.since) - p.avgWait) / 4
+	return move{ticket: t, to: r}
+}
+
+func (p *pool) remove(drop func(t ticket) bool) {
+	q := p.queue[:0]
+	for _, t := range p.queue {
+		if !drop(t) {
+			q = append(q, t)
+		}
+	}
+	p.queue = q
+}
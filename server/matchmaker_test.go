@@ -0,0 +1,124 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// matchmaking is a registry with the started lobby and a matchmaker of the "game" pool
+func matchmaking(t *testing.T, p Pool) (*Registry, *Matchmaker, *Room) {
+	reg := NewRegistry(time.Minute)
+	m := NewMatchmaker(reg)
+	t.Cleanup(func() {
+		m.Close()
+		reg.Close()
+		for _, r := range reg.Rooms() {
+			r.Stop()
+		}
+	})
+	p.NewRoom = lobbyRoom
+	if err := m.AddPool("game", p); err != nil {
+		t.Fatal(err)
+	}
+	lobby := lobbyRoom()
+	if err := reg.Register("lobby", lobby); err != nil {
+		t.Fatal(err)
+	}
+	lobby.Start()
+	return reg, m, lobby
+}
+
+// queue connects n players to the lobby and puts them to the queue of the game
+func queue(t *testing.T, m *Matchmaker, lobby *Room, connect func() func(), n int) {
+	before := 0
+	_ = lobby.Do(func(r *Room) { before = len(r.clients) })
+	for i := 0; i < n; i++ {
+		t.Cleanup(connect())
+	}
+	eventually(t, lobby, "players haven't joined the lobby", func(r *Room) bool { return len(r.clients) == before+n })
+	_ = lobby.Do(func(r *Room) {
+		for id := range r.clients {
+			if err := m.Enqueue("game", r, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func instance(t *testing.T, reg *Registry, key string) *Room {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if r, ok := reg.Lookup(key); ok {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("room %s is not made", key)
+	return nil
+}
+
+func TestMatchmakerWaitsForMinPlayers(t *testing.T) {
+	reg, m, lobby := matchmaking(t, Pool{MinPlayers: 2, MaxPlayers: 4, Wait: 100 * time.Millisecond})
+	connect := serve(t, lobby)
+	queue(t, m, lobby, connect, 1)
+	time.Sleep(matchInterval + 200*time.Millisecond)
+	if _, ok := reg.Lookup("game-1"); ok {
+		t.Fatal("room is made for one player of two")
+	}
+	queue(t, m, lobby, connect, 1)
+	game := instance(t, reg, "game-1")
+	eventually(t, game, "players are not transferred", players(2))
+	eventually(t, lobby, "players are still in the lobby", players(0))
+}
+
+func TestMatchmakerMakesRoomOfMaxPlayers(t *testing.T) {
+	reg, m, lobby := matchmaking(t, Pool{MinPlayers: 2, MaxPlayers: 2, Wait: time.Hour})
+	queue(t, m, lobby, serve(t, lobby), 3)
+	game := instance(t, reg, "game-1")
+	eventually(t, game, "full room is not filled", players(2))
+	eventually(t, lobby, "the third player is not left waiting", players(1))
+	if _, ok := reg.Lookup("game-2"); ok {
+		t.Fatal("room is made for one player of two")
+	}
+}
+
+func TestOverflowOfFullRoom(t *testing.T) {
+	reg, m, _ := matchmaking(t, Pool{MinPlayers: 1, MaxPlayers: 2, Wait: time.Hour})
+	m.lock.Lock()
+	p := m.pools["game"]
+	full, err := m.instance(p)
+	p.instances[full] = p.MaxPlayers
+	m.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := m.overflow(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == full || other.Key() != "game-2" {
+		t.Fatalf("overflow is %s, want a new instance", other.Key())
+	}
+	// there is a place left
+	if again, err := m.overflow(full); err != nil || again != other {
+		t.Fatalf("second overflow is %v %v, want the same instance", again, err)
+	}
+	if _, err := m.overflow(lobbyRoom()); !errors.Is(err, RoomFull) {
+		t.Fatalf("overflow of the room without pool is %v", err)
+	}
+	if len(reg.Keys()) != 3 {
+		t.Fatalf("rooms %v, want lobby and two instances", reg.Keys())
+	}
+}
+
+func TestClosedMatchmakerMakesNoRooms(t *testing.T) {
+	_, m, _ := matchmaking(t, Pool{MinPlayers: 1, MaxPlayers: 1})
+	m.Close()
+	m.lock.Lock()
+	_, err := m.instance(m.pools["game"])
+	m.lock.Unlock()
+	if !errors.Is(err, MatchmakerClosed) {
+		t.Fatalf("closed matchmaker made room: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/arovesto/gio/metrics"
+)
+
+// reasons of the dropped events
+const (
+	droppedRejected  = "rejected"   // client was not allowed to send it or was over the rate limit
+	droppedInbox     = "inbox"      // room didn't keep up with the client
+	droppedSendQueue = "send_queue" // client didn't keep up with the room
+)
+
+// meter is what the room reports to the metrics registry, nil meter reports nothing
+type meter struct {
+	reg  *metrics.Registry
+	room string
+
+	tick        *metrics.Histogram
+	overloads   *metrics.Counter
+	queue       *metrics.Gauge
+	clients     *metrics.Gauge
+	rejected    *metrics.Counter
+	inbox       *metrics.Counter
+	sendQueue   *metrics.Counter
+	bytesIn     *metrics.Counter
+	messagesIn  *metrics.Counter
+	bytesOut    *metrics.Counter
+	messagesOut *metrics.Counter
+	writeErrors *metrics.Counter
+
+	elements *metrics.GaugeVec
+	types    map[int]*metrics.Gauge // element types the room ever had, used by room goroutine only
+}
+
+func newMeter(reg *metrics.Registry, room string) *meter {
+	if reg == nil {
+		return nil
+	}
+	dropped := reg.Counter("gio_dropped_events_total", "Events dropped by the room.", "room", "reason")
+	return &meter{
+		reg:         reg,
+		room:        room,
+		tick:        reg.Histogram("gio_tick_duration_seconds", "Time one simulation step of the room takes.", nil, "room").With(room),
+		overloads:   reg.Counter("gio_tick_overloads_total", "Times the room couldn't keep up and dropped steps.", "room").With(room),
+		queue:       reg.Gauge("gio_event_queue_depth", "Events of clients waiting for the room.", "room").With(room),
+		clients:     reg.Gauge("gio_clients", "Clients connected to the room, spectators included.", "room").With(room),
+		rejected:    dropped.With(room, droppedRejected),
+		inbox:       dropped.With(room, droppedInbox),
+		sendQueue:   dropped.With(room, droppedSendQueue),
+		bytesIn:     reg.Counter("gio_received_bytes_total", "Bytes clients sent to the room.", "room").With(room),
+		messagesIn:  reg.Counter("gio_received_messages_total", "Messages clients sent to the room.", "room").With(room),
+		bytesOut:    reg.Counter("gio_sent_bytes_total", "Bytes the room sent to clients.", "room").With(room),
+		messagesOut: reg.Counter("gio_sent_messages_total", "Messages the room sent to clients.", "room").With(room),
+		writeErrors: reg.Counter("gio_write_errors_total", "Failed writes to clients of the room.", "room").With(room),
+		elements:    reg.Gauge("gio_elements", "Elements of the room by type.", "room", "type"),
+		types:       map[int]*metrics.Gauge{},
+	}
+}
+
+// measure reports the tick which started at start
+func (m *meter) measure(s *Room, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.tick.Observe(time.Since(start).Seconds())
+	depth := 0
+	for _, p := range s.clients {
+		depth += p.session.in.len()
+	}
+	m.queue.Set(float64(depth))
+	m.clients.Set(float64(len(s.clients)))
+	// counting is not free, once a second is enough
+	if tps := uint64(time.Second / s.TickDuration()); tps == 0 || s.tick%tps == 1 {
+		m.countElements(s)
+	}
+}
+
+func (m *meter) drop(reason string) {
+	if m == nil {
+		return
+	}
+	switch reason {
+	case droppedRejected:
+		m.rejected.Inc()
+	case droppedInbox:
+		m.inbox.Inc()
+	case droppedSendQueue:
+		m.sendQueue.Inc()
+	}
+}
+
+func (m *meter) overloaded() {
+	if m == nil {
+		return
+	}
+	m.overloads.Inc()
+}
+
+func (m *meter) writeFailed() {
+	if m == nil {
+		return
+	}
+	m.writeErrors.Inc()
+}
+
+func (m *meter) received(size int) {
+	if m == nil {
+		return
+	}
+	m.bytesIn.Add(float64(size))
+	m.messagesIn.Inc()
+}
+
+func (m *meter) sent(size int) {
+	if m == nil {
+		return
+	}
+	m.bytesOut.Add(float64(size))
+	m.messagesOut.Inc()
+}
+
+// countElements sets number of elements of every type, types the room has no more are zero
+func (m *meter) countElements(s *Room) {
+	if m == nil {
+		return
+	}
+	counts := map[int]int{}
+	for _, e := range s.elements {
+		counts[e.GetType()]++
+	}
+	for tp := range counts {
+		if _, ok := m.types[tp]; !ok {
+			m.types[tp] = m.elements.With(m.room, strconv.Itoa(tp))
+		}
+	}
+	for tp, g := range m.types {
+		g.Set(float64(counts[tp]))
+	}
+}
+
+// Metrics are metrics of the room, nil if room is not registered in the registry with metrics
+func (s *Room) Metrics() *metrics.Registry {
+	if s.meter == nil {
+		return nil
+	}
+	return s.meter.reg
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+var ClientTooSlow = errors.New("client is too slow")
+
+// QueuePolicy says what to do when client's send queue is full
+type QueuePolicy int
+
+const (
+	// DropUpdates drops unreliable messages (updates), reliable ones are kept until queue is twice as big
+	DropUpdates QueuePolicy = iota
+	// Disconnect closes connection of the client who can't keep up
+	Disconnect
+)
+
+const (
+	DefaultSendQueue    = 256
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+// QueueStats describes send queue of one client
+type QueueStats struct {
+	Depth   int
+	Sent    uint64
+	Dropped uint64
+	Errors  uint64
+}
+
+type message struct {
+	data     []byte
+	reliable bool
+	meter    *meter // of the room which sent it
+}
+
+// outbound is a bounded send queue of one client drained by its own writer goroutine
+type outbound struct {
+	lock   sync.Mutex
+	queue  []message
+	signal chan struct{}
+	closed bool
+	stats  QueueStats
+
+	size         int
+	policy       QueuePolicy
+	writeTimeout time.Duration
+	msgType      websocket.MessageType
+}
+
+func newOutbound(size int, policy QueuePolicy, writeTimeout time.Duration, binary bool) *outbound {
+	if size <= 0 {
+		size = DefaultSendQueue
+	}
+	if writeTimeout <= 0 {
+		writeTimeout = DefaultWriteTimeout
+	}
+	msgType := websocket.MessageText
+	if binary {
+		msgType = websocket.MessageBinary
+	}
+	return &outbound{
+		signal:       make(chan struct{}, 1),
+		size:         size,
+		policy:       policy,
+		writeTimeout: writeTimeout,
+		msgType:      msgType,
+	}
+}
+
+// push adds message to the queue, error means client can't keep up and should be disconnected
+func (o *outbound) push(m message) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.closed {
+		return ClientTooSlow
+	}
+	if len(o.queue) >= o.size {
+		if o.policy == Disconnect {
+			o.closed = true
+			return ClientTooSlow
+		}
+		if !m.reliable {
+			o.stats.Dropped++
+			m.meter.drop(droppedSendQueue)
+			return nil
+		}
+		if !o.dropUnreliable() && len(o.queue) >= 2*o.size {
+			o.closed = true
+			return ClientTooSlow
+		}
+	}
+	o.queue = append(o.queue, m)
+	select {
+	case o.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dropUnreliable frees place in the queue by dropping the oldest unreliable message
+func (o *outbound) dropUnreliable() bool {
+	for i, m := range o.queue {
+		if !m.reliable {
+			o.queue = append(o.queue[:i], o.queue[i+1:]...)
+			o.stats.Dropped++
+			m.meter.drop(droppedSendQueue)
+			return true
+		}
+	}
+	return false
+}
+
+func (o *outbound) pop() []message {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	q := o.queue
+	o.queue = nil
+	return q
+}
+
+func (o *outbound) Stats() QueueStats {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	st := o.stats
+	st.Depth = len(o.queue)
+	return st
+}
+
+// write sends everything from the queue until ctx is done or write fails
+func (o *outbound) write(ctx context.Context, c *websocket.Conn) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-o.signal:
+		}
+		for _, m := range o.pop() {
+			wctx, cancel := context.WithTimeout(ctx, o.writeTimeout)
+			err := c.Write(wctx, o.msgType, m.data)
+			cancel()
+			o.lock.Lock()
+			if err != nil {
+				o.stats.Errors++
+				o.closed = true
+			} else {
+				o.stats.Sent++
+			}
+			o.lock.Unlock()
+			if err != nil {
+				m.meter.writeFailed()
+			} else {
+				m.meter.sent(len(m.data))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func msg(data string, reliable bool) message {
+	return message{data: []byte(data), reliable: reliable}
+}
+
+func contents(o *outbound) (res []string) {
+	for _, m := range o.pop() {
+		res = append(res, string(m.data))
+	}
+	return
+}
+
+func TestDropUpdatesKeepsReliable(t *testing.T) {
+	o := newOutbound(2, DropUpdates, 0, false)
+	for _, m := range []message{msg("u1", false), msg("r1", true), msg("u2", false), msg("r2", true)} {
+		if err := o.push(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// u2 is dropped as the queue is full, r2 takes the place of u1
+	got := contents(o)
+	if len(got) != 2 || got[0] != "r1" || got[1] != "r2" {
+		t.Fatalf("queue is %v, want reliable messages only", got)
+	}
+	if st := o.Stats(); st.Dropped != 2 {
+		t.Fatalf("%d messages are dropped, want 2", st.Dropped)
+	}
+}
+
+func TestDropUpdatesKicksAfterTwiceTheSize(t *testing.T) {
+	o := newOutbound(2, DropUpdates, 0, false)
+	for i := 0; i < 4; i++ {
+		if err := o.push(msg("r", true)); err != nil {
+			t.Fatalf("reliable message %d: %v", i, err)
+		}
+	}
+	if err := o.push(msg("r", true)); !errors.Is(err, ClientTooSlow) {
+		t.Fatalf("reliable message over twice the size is %v", err)
+	}
+	if err := o.push(msg("u", false)); !errors.Is(err, ClientTooSlow) {
+		t.Fatal("closed queue takes messages")
+	}
+}
+
+func TestDisconnectPolicy(t *testing.T) {
+	o := newOutbound(2, Disconnect, 0, false)
+	for i := 0; i < 2; i++ {
+		if err := o.push(msg("u", false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.push(msg("u", false)); !errors.Is(err, ClientTooSlow) {
+		t.Fatalf("message over the size is %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/arovesto/gio/logging"
+	"github.com/arovesto/gio/storage"
+)
+
+// WithPersistence makes room save its snapshot to the storage of the registry every interval and on stop,
+// room is restored from the latest snapshot when it is registered. Zero interval is the one of the registry.
+// Elements of the players are not saved, players get new ones when they join
+func WithPersistence(every time.Duration) RoomOption {
+	return func(r *Room) {
+		r.persistent = true
+		r.persistEvery = every
+	}
+}
+
+// restore sets state of the room to the latest snapshot, room keeps its elements if there is none
+func (s *Room) restore() {
+	data, err := s.storage.Load(s.key)
+	if errors.Is(err, storage.NotFound) {
+		return
+	}
+	if err != nil {
+		s.Logger().Error("failed to load snapshot", logging.Err(err))
+		return
+	}
+	id, tp := s.ID, s.Type
+	if err := s.SetState(data); err != nil {
+		s.ID, s.Type = id, tp
+		s.Logger().Error("failed to restore room", logging.Err(err))
+		return
+	}
+	s.Logger().Info("room is restored", logging.F("elements", len(s.elements)))
+}
+
+// persistentState is the state of the room without elements of the players
+func (s *Room) persistentState() ([]byte, error) {
+	return s.stateOf(func(id int) bool {
+		_, playing := s.clients[id]
+		_, away := s.away[id]
+		return !playing && !away
+	})
+}
+
+// startSaver starts goroutine which writes snapshots, so slow storage doesn't slow down the ticks
+func (s *Room) startSaver() {
+	s.saves = make(chan []byte, 1)
+	s.saverDone = make(chan struct{})
+	go func() {
+		defer close(s.saverDone)
+		for data := range s.saves {
+			if err := s.storage.Save(s.key, data); err != nil {
+				s.Logger().Error("failed to save snapshot", logging.Err(err))
+			}
+		}
+	}()
+}
+
+// persist hands the snapshot to the saver, it is skipped if the previous one is not written yet
+func (s *Room) persist() {
+	data, err := s.persistentState()
+	if err != nil {
+		s.logAt(nil).Error("failed to make snapshot", logging.Err(err))
+		return
+	}
+	select {
+	case s.saves <- data:
+	default:
+		s.logAt(nil).Repeated(logging.WarnLevel, "snapshot is skipped, storage is slow")
+	}
+}
+
+// persistLast waits for the saver and writes the last snapshot, it is done when room stops
+func (s *Room) persistLast() {
+	if s.saves == nil {
+		return
+	}
+	close(s.saves)
+	<-s.saverDone
+	data, err := s.persistentState()
+	if err == nil {
+		err = s.storage.Save(s.key, data)
+	}
+	if err != nil {
+		s.logAt(nil).Error("failed to save snapshot on stop", logging.Err(err))
+	}
+}
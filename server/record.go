@@ -0,0 +1,218 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
+)
+
+// RecordingExt is the extension of recordings the registry makes
+const RecordingExt = ".rec.gz"
+
+// how often the recording has state of the room to check replay against
+const checkpointEvery = time.Second
+
+// kinds of records
+const (
+	recordStart  = "start"  // state of the room when it started, always the first one
+	recordEvent  = "event"  // event which entered ProcessEvent
+	recordAdd    = "add"    // element added outside of the simulation, e.g. for a new player
+	recordDelete = "delete" // element deleted outside of the simulation
+	recordAway   = "away"   // element of the disconnected player is frozen
+	recordBack   = "back"   // element is not frozen anymore
+	recordLeft   = "left"   // player was transferred in the middle of the tick, replay has no room to send him to
+	recordState  = "state"  // state of the room after the tick
+	recordEnd    = "end"    // room is stopped, the last one
+)
+
+// Record is one line of the recording. Recording is gzipped JSON lines: the start record, then changes of the room
+// tagged by the tick they happened before simulation of, with state checkpoints after some ticks
+type Record struct {
+	Kind         string
+	Tick         uint64
+	RoomType     string          `json:",omitempty"` // start record only
+	TickDuration time.Duration   `json:",omitempty"` // start record only
+	At           int64           `json:",omitempty"` // start record only, room time in unix nanoseconds
+	Seed         int64           `json:",omitempty"` // start record only, of the random source of the room
+	Drawn        uint64          `json:",omitempty"` // start record only, numbers the random source has made
+	ID           int             `json:",omitempty"` // of the element
+	Type         int             `json:",omitempty"` // of the added element
+	Event        *event.Event    `json:",omitempty"`
+	State        json.RawMessage `json:",omitempty"` // of the room, or of the added element
+}
+
+// recorder writes what happens with the room, it is used by room goroutine only
+type recorder struct {
+	out     io.Writer
+	zw      *gzip.Writer
+	enc     *json.Encoder
+	started bool
+	muted   bool // room simulates the tick, replay makes the same changes by itself
+	failed  bool
+	every   uint64 // ticks between checkpoints
+}
+
+// WithRecorder makes room record its start state and everything it processes to w, see Replay.
+// Recording is finished when room stops, w is closed then if it is io.Closer
+func WithRecorder(w io.Writer) RoomOption {
+	return func(r *Room) {
+		r.rec = newRecorder(w)
+	}
+}
+
+func newRecorder(w io.Writer) *recorder {
+	zw := gzip.NewWriter(w)
+	return &recorder{out: w, zw: zw, enc: json.NewEncoder(zw)}
+}
+
+// recordTo makes recorder to a new file in dir, it is named after the room key and the time
+func recordTo(dir, key string) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := strings.NewReplacer("/", "_", `\`, "_").Replace(key)
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, time.Now().Unix(), RecordingExt)))
+	if err != nil {
+		return nil, err
+	}
+	return newRecorder(f), nil
+}
+
+// recording reports changes which must be recorded, nil recorder records nothing
+func (r *recorder) recording() bool {
+	return r != nil && r.started && !r.muted && !r.failed
+}
+
+// mute stops recording while the room simulates
+func (r *recorder) mute(muted bool) {
+	if r != nil {
+		r.muted = muted
+	}
+}
+
+func (r *recorder) write(s *Room, rec Record) {
+	if err := r.enc.Encode(rec); err != nil {
+		r.failed = true
+		s.logAt(nil).Error("recording is stopped", logging.Err(err))
+	}
+}
+
+// start writes the state the recording begins with
+func (r *recorder) start(s *Room) {
+	if r == nil {
+		return
+	}
+	st, err := s.GetState()
+	if err != nil {
+		r.failed = true
+		s.logAt(nil).Error("recording is not started", logging.Err(err))
+		return
+	}
+	r.every = uint64(checkpointEvery / s.TickDuration())
+	if r.every == 0 {
+		r.every = 1
+	}
+	r.started = true
+	rng := s.Rand()
+	r.write(s, Record{
+		Kind:         recordStart,
+		Tick:         s.tick,
+		RoomType:     s.Type,
+		TickDuration: s.TickDuration(),
+		At:           s.Now().UnixNano(),
+		Seed:         rng.Seed(),
+		Drawn:        rng.Drawn(),
+		State:        st,
+	})
+}
+
+// change writes change of the room, it is applied before simulation of the current tick, or of the next one
+// if room is between ticks
+func (r *recorder) change(s *Room, rec Record) {
+	if !r.recording() {
+		return
+	}
+	rec.Tick = s.tick
+	if !s.stepping {
+		rec.Tick++
+	}
+	r.write(s, rec)
+}
+
+func (r *recorder) event(s *Room, e event.Event) {
+	r.change(s, Record{Kind: recordEvent, Event: &e})
+}
+
+func (r *recorder) added(s *Room, el elements.Element, st []byte) {
+	r.change(s, Record{Kind: recordAdd, ID: el.GetID(), Type: el.GetType(), State: st})
+}
+
+func (r *recorder) deleted(s *Room, id int) {
+	r.change(s, Record{Kind: recordDelete, ID: id})
+}
+
+// left writes transfer made by the simulation, replay repeats its deletion when the simulation tries it again.
+// Transfers between ticks are recorded as deletions
+func (r *recorder) left(s *Room, id int) {
+	if r == nil || !r.started || r.failed || !r.muted {
+		return
+	}
+	r.write(s, Record{Kind: recordLeft, Tick: s.tick, ID: id})
+}
+
+func (r *recorder) away(s *Room, id int, away bool) {
+	kind := recordBack
+	if away {
+		kind = recordAway
+	}
+	r.change(s, Record{Kind: kind, ID: id})
+}
+
+// checkpoint writes state of the room after the tick once in a while
+func (r *recorder) checkpoint(s *Room) {
+	if !r.recording() || s.tick%r.every != 0 {
+		return
+	}
+	r.state(s, recordState)
+	// so recording of the crashed room can be replayed up to here
+	if err := r.zw.Flush(); err != nil {
+		r.failed = true
+		s.logAt(nil).Error("recording is stopped", logging.Err(err))
+	}
+}
+
+func (r *recorder) state(s *Room, kind string) {
+	st, err := s.GetState()
+	if err != nil {
+		s.logAt(nil).Error("failed to get state for recording", logging.Err(err))
+		return
+	}
+	r.write(s, Record{Kind: kind, Tick: s.tick, State: st})
+}
+
+// finish writes the final state and flushes the recording
+func (r *recorder) finish(s *Room) {
+	if r == nil {
+		return
+	}
+	if r.started && !r.failed {
+		r.state(s, recordEnd)
+	}
+	if err := r.zw.Close(); err != nil {
+		s.logAt(nil).Error("failed to finish recording", logging.Err(err))
+	}
+	if c, ok := r.out.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			s.logAt(nil).Error("failed to close recording", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arovesto/gio/logging"
+	"github.com/arovesto/gio/metrics"
+	"github.com/arovesto/gio/storage"
+)
+
+var (
+	RoomNotFound = errors.New("room not found")
+	RoomExists   = errors.New("room already exists")
+)
+
+const DefaultEmptyRoomTimeout = time.Minute
+
+type registered struct {
+	room      *Room
+	temporary bool
+}
+
+// Registry keeps rooms of the server by stable keys, so they can be found for transfers.
+// Temporary rooms are stopped and removed when nobody is playing in them for a grace period
+type Registry struct {
+	lock    sync.RWMutex
+	rooms   map[string]registered
+	pending map[string]struct{} // keys of the rooms being registered, they are not found until restored
+	grace   time.Duration
+	tps     int               // tick rate of the rooms without own one
+	log     logging.Logger    // of the registry and rooms without own one
+	metrics *metrics.Registry // of the rooms, nil if they are not collected
+	storage storage.Storage   // of the persistent rooms, nil if they are not saved
+	// how often persistent rooms are saved if they don't say
+	persistEvery time.Duration
+	recordDir    string // rooms without own recorder are recorded here, nothing is recorded if it is empty
+	done         chan struct{}
+	once         sync.Once
+}
+
+func NewRegistry(grace time.Duration) *Registry {
+	if grace <= 0 {
+		grace = DefaultEmptyRoomTimeout
+	}
+	r := &Registry{
+		rooms:   map[string]registered{},
+		pending: map[string]struct{}{},
+		grace:   grace,
+		log:     logging.Default(),
+		done:    make(chan struct{}),
+	}
+	go r.cleanup()
+	return r
+}
+
+// Register adds room which lives until it is destroyed explicitly
+func (r *Registry) Register(key string, room *Room) error {
+	return r.register(key, room, false)
+}
+
+// RegisterTemporary adds room which is destroyed after it has no players for a grace period
+func (r *Registry) RegisterTemporary(key string, room *Room) error {
+	return r.register(key, room, true)
+}
+
+func (r *Registry) register(key string, room *Room, temporary bool) error {
+	r.lock.Lock()
+	_, exists := r.rooms[key]
+	if _, ok := r.pending[key]; ok || exists {
+		r.lock.Unlock()
+		return RoomExists
+	}
+	r.pending[key] = struct{}{}
+	r.lock.Unlock()
+	// restore reads the storage, other rooms are not blocked meanwhile
+	room.setRegistry(r, key)
+	room.inherit(r)
+	r.lock.Lock()
+	delete(r.pending, key)
+	r.rooms[key] = registered{room: room, temporary: temporary}
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *Registry) Lookup(key string) (*Room, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	rg, ok := r.rooms[key]
+	return rg.room, ok
+}
+
+// Rooms is a copy of all registered rooms
+func (r *Registry) Rooms() map[string]*Room {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	res := make(map[string]*Room, len(r.rooms))
+	for k, rg := range r.rooms {
+		res[k] = rg.room
+	}
+	return res
+}
+
+func (r *Registry) Keys() (keys []string) {
+	r.lock.RLock()
+	for k := range r.rooms {
+		keys = append(keys, k)
+	}
+	r.lock.RUnlock()
+	sort.Strings(keys)
+	return
+}
+
+// Destroy stops the room and removes it from the registry
+func (r *Registry) Destroy(key string) error {
+	r.lock.Lock()
+	rg, ok := r.rooms[key]
+	delete(r.rooms, key)
+	r.lock.Unlock()
+	if !ok {
+		return RoomNotFound
+	}
+	rg.room.Stop()
+	if r.metrics != nil {
+		r.metrics.Delete("room", key)
+	}
+	return nil
+}
+
+// Close stops cleanup of the empty rooms, rooms itself are left as is
+func (r *Registry) Close() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+}
+
+// StopAll stops every room and waits until they are saved and stopped, or until ctx is done.
+// Rooms stay registered, so they can be looked at after
+func (r *Registry) StopAll(ctx context.Context) error {
+	rooms := r.Rooms()
+	for _, room := range rooms {
+		room.StopWithReason("server is shutting down")
+	}
+	for _, room := range rooms {
+		select {
+		case <-room.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (r *Registry) cleanup() {
+	interval := r.grace / 2
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			for _, key := range r.expired() {
+				if err := r.Destroy(key); err == nil {
+					r.log.Info("room is destroyed", logging.RoomKey(key))
+				}
+			}
+		}
+	}
+}
+
+func (r *Registry) expired() (keys []string) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for k, rg := range r.rooms {
+		if rg.room.CurrentState() == Stopped {
+			keys = append(keys, k)
+			continue
+		}
+		if !rg.temporary {
+			continue
+		}
+		if since, empty := rg.room.EmptySince(); empty && time.Since(since) > r.grace {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
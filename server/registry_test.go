@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistryRejectsTheSameKey(t *testing.T) {
+	reg := NewRegistry(time.Minute)
+	defer reg.Close()
+	if err := reg.Register("lobby", lobbyRoom()); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.RegisterTemporary("lobby", lobbyRoom()); !errors.Is(err, RoomExists) {
+		t.Fatalf("second room of the key is registered: %v", err)
+	}
+	if _, ok := reg.Lookup("lobby"); !ok {
+		t.Fatal("registered room is not found")
+	}
+}
+
+func TestRegistryStopAllWaitsForRooms(t *testing.T) {
+	reg := NewRegistry(time.Minute)
+	defer reg.Close()
+	running, created := lobbyRoom(), lobbyRoom()
+	for key, room := range map[string]*Room{"running": running, "created": created} {
+		if err := reg.Register(key, room); err != nil {
+			t.Fatal(err)
+		}
+	}
+	running.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := reg.StopAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, room := range []*Room{running, created} {
+		if room.CurrentState() != Stopped {
+			t.Fatalf("room is %d after StopAll", room.CurrentState())
+		}
+	}
+}
+
+func TestRegistryDestroysEmptyTemporaryRooms(t *testing.T) {
+	reg := NewRegistry(50 * time.Millisecond)
+	defer reg.Close()
+	room := lobbyRoom()
+	if err := reg.RegisterTemporary("game", room); err != nil {
+		t.Fatal(err)
+	}
+	room.Start()
+	select {
+	case <-room.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("empty temporary room is not destroyed")
+	}
+	if _, ok := reg.Lookup("game"); ok {
+		t.Fatal("destroyed room is found")
+	}
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/math"
+)
+
+var BadRecording = errors.New("bad recording")
+
+// problems of the replayed elements
+const (
+	DivergedMissing = "missing" // recorded element is not in the replayed room
+	DivergedExtra   = "extra"   // replayed room has element which is not recorded
+	DivergedState   = "state"   // element is in both, states differ
+)
+
+// Divergence is an element of the replayed room which is not as it was recorded
+type Divergence struct {
+	Tick    uint64
+	Element int
+	Problem string
+	// Patch is JSON merge patch which turns replayed state into the recorded one,
+	// or the whole recorded state if there is no such patch
+	Patch json.RawMessage `json:",omitempty"`
+}
+
+// ReplayReport is what replay found, recording is reproduced if there are no divergences
+type ReplayReport struct {
+	RoomType    string
+	Ticks       uint64 // simulated ones
+	Events      int
+	Checkpoints int  // recorded states replay was checked against
+	Complete    bool // recording has its end, otherwise room didn't stop properly and the rest is lost
+	Divergences []Divergence
+}
+
+func (r *ReplayReport) Diverged() bool {
+	return len(r.Divergences) != 0
+}
+
+// Replay feeds recording made WithRecorder into a fresh room and checks it against the recorded states.
+// Room is made by newRoom for the recorded room type, so it has the same handlers and options, basic room is used
+// if newRoom is nil or returns nil. Room is never started: ticks are simulated one by one with the recorded tick
+// duration, clock and random seed. Element types of the recording must be in GenElements
+func Replay(r io.Reader, newRoom func(tp string) *Room) (*ReplayReport, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", BadRecording, err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+	var start Record
+	if err := dec.Decode(&start); err != nil {
+		return nil, fmt.Errorf("%w: %v", BadRecording, err)
+	}
+	if start.Kind != recordStart || start.TickDuration <= 0 {
+		return nil, fmt.Errorf("%w: it doesn't start with the room state", BadRecording)
+	}
+
+	var room *Room
+	if newRoom != nil {
+		room = newRoom(start.RoomType)
+	}
+	if room == nil {
+		room = NewBasicRoom(0, start.RoomType, nil)
+	}
+	if err := room.SetState(start.State); err != nil {
+		return nil, fmt.Errorf("failed to set start state: %w", err)
+	}
+	room.tick = start.Tick
+	room.tickRate = int(time.Second / start.TickDuration)
+	room.epoch = time.Unix(0, start.At).Add(-time.Duration(start.Tick) * start.TickDuration)
+	room.rng = math.NewRandAt(start.Seed, start.Drawn)
+	rp := &replayer{
+		room:   room,
+		delta:  start.TickDuration,
+		report: &ReplayReport{RoomType: start.RoomType},
+	}
+
+	for {
+		var rec Record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// room crashed or is still running, what was written is replayed
+			return rp.report, nil
+		}
+		if err != nil {
+			return rp.report, fmt.Errorf("%w: %v", BadRecording, err)
+		}
+		switch rec.Kind {
+		case recordEvent, recordAdd, recordDelete, recordAway, recordBack, recordLeft:
+			err = rp.queue(rec)
+		case recordState:
+			err = rp.check(rec)
+		case recordEnd:
+			// changes made after the last tick are in the final state
+			if err = rp.advance(rec.Tick); err == nil && rp.pendingTick == rec.Tick+1 {
+				err = rp.applyPending()
+			}
+			if err == nil {
+				err = rp.check(rec)
+			}
+			rp.report.Complete = true
+			return rp.report, err
+		default:
+			err = fmt.Errorf("%w: unknown record %q", BadRecording, rec.Kind)
+		}
+		if err != nil {
+			return rp.report, err
+		}
+	}
+}
+
+// replayer simulates the room tick by tick, changes are kept until the tick they were recorded for
+type replayer struct {
+	room        *Room
+	delta       time.Duration
+	pending     []Record
+	pendingTick uint64
+	report      *ReplayReport
+}
+
+func (rp *replayer) queue(rec Record) error {
+	if rec.Tick <= rp.room.tick {
+		return fmt.Errorf("%w: record of tick %d after tick %d", BadRecording, rec.Tick, rp.room.tick)
+	}
+	if rec.Tick != rp.pendingTick {
+		if err := rp.advance(rec.Tick - 1); err != nil {
+			return err
+		}
+		rp.pendingTick = rec.Tick
+	}
+	rp.pending = append(rp.pending, rec)
+	return nil
+}
+
+// advance simulates ticks up to the tick
+func (rp *replayer) advance(tick uint64) error {
+	if tick < rp.room.tick {
+		return fmt.Errorf("%w: tick %d after tick %d", BadRecording, tick, rp.room.tick)
+	}
+	for rp.room.tick < tick {
+		if err := rp.step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step is Step of the room with recorded changes instead of clients
+func (rp *replayer) step() error {
+	s := rp.room
+	s.tick++
+	s.stepping = true
+	defer func() { s.stepping = false }()
+	s.snapshot()
+	if rp.pendingTick == s.tick {
+		if err := rp.applyPending(); err != nil {
+			return err
+		}
+	}
+	s.Update(rp.delta)
+	s.left = nil
+	s.flush()
+	s.recordColliders(s.Now())
+	rp.report.Ticks++
+	return nil
+}
+
+func (rp *replayer) applyPending() error {
+	recs := rp.pending
+	rp.pending = nil
+	for _, rec := range recs {
+		if err := rp.apply(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rp *replayer) apply(rec Record) error {
+	s := rp.room
+	switch rec.Kind {
+	case recordEvent:
+		if rec.Event == nil {
+			return fmt.Errorf("%w: event record of tick %d has no event", BadRecording, rec.Tick)
+		}
+		rp.report.Events++
+		// errors are the same as the recorded room had, it just went on
+		_ = s.ProcessEvent(*rec.Event)
+	case recordAdd:
+		el, err := elementOf(rec.Type, rec.State)
+		if err != nil {
+			return err
+		}
+		s.NewElement(el)
+	case recordDelete:
+		s.DeleteElement(rec.ID)
+	case recordAway:
+		s.away[rec.ID] = &awayPlayer{}
+	case recordBack:
+		delete(s.away, rec.ID)
+	case recordLeft:
+		if s.left == nil {
+			s.left = map[int]struct{}{}
+		}
+		s.left[rec.ID] = struct{}{}
+	}
+	return nil
+}
+
+// check compares elements of the room with the recorded state
+func (rp *replayer) check(rec Record) error {
+	if err := rp.advance(rec.Tick); err != nil {
+		return err
+	}
+	rp.report.Checkpoints++
+	var recorded struct {
+		Elements []RawElement `json:"elements"`
+	}
+	if err := json.Unmarshal(rec.State, &recorded); err != nil {
+		return fmt.Errorf("%w: state of tick %d: %v", BadRecording, rec.Tick, err)
+	}
+	want := make(map[int][]byte, len(recorded.Elements))
+	for _, raw := range recorded.Elements {
+		el, err := elementOf(raw.Type, raw.Data)
+		if err != nil {
+			return err
+		}
+		want[el.GetID()] = raw.Data
+	}
+	got := make(map[int][]byte, len(rp.room.elements))
+	for id, e := range rp.room.elements {
+		st, err := e.GetState()
+		if err != nil {
+			return err
+		}
+		got[id] = st
+	}
+
+	var found []Divergence
+	for id, w := range want {
+		g, ok := got[id]
+		if !ok {
+			found = append(found, Divergence{Tick: rec.Tick, Element: id, Problem: DivergedMissing, Patch: w})
+			continue
+		}
+		if same, err := sameJSON(g, w); err != nil || !same {
+			patch, ok, _ := event.Diff(g, w)
+			if !ok {
+				patch = w
+			}
+			found = append(found, Divergence{Tick: rec.Tick, Element: id, Problem: DivergedState, Patch: patch})
+		}
+	}
+	for id := range got {
+		if _, ok := want[id]; !ok {
+			found = append(found, Divergence{Tick: rec.Tick, Element: id, Problem: DivergedExtra})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Element < found[j].Element })
+	rp.report.Divergences = append(rp.report.Divergences, found...)
+	return nil
+}
+
+func elementOf(tp int, state []byte) (elements.Element, error) {
+	gen, ok := elements.GenElements[tp]
+	if !ok {
+		return nil, fmt.Errorf("failed to locate entity type %d", tp)
+	}
+	el := gen()
+	if err := el.SetState(state); err != nil {
+		return nil, err
+	}
+	return el, nil
+}
+
+// sameJSON reports documents with the same values, order of the keys doesn't matter
+func sameJSON(a, b []byte) (bool, error) {
+	var av, bv interface{}
+	if err := json.Unmarshal(a, &av); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(av, bv), nil
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/event"
+)
+
+const counterType = 90001
+
+// counter is a player which counts its moves and loses after Lives of them
+type counter struct {
+	ID    int
+	Moves int
+	Lives int
+	Roll  int // random number of the last move, so replay checks random source too
+}
+
+func (c *counter) GetID() int                 { return c.ID }
+func (c *counter) GetType() int               { return counterType }
+func (c *counter) GetState() ([]byte, error)  { return json.Marshal(c) }
+func (c *counter) SetState(data []byte) error { return json.Unmarshal(data, c) }
+func (c *counter) Input() ([]byte, error)     { return nil, nil }
+func (c *counter) SetInput(data []byte) error { return nil }
+func (c *counter) Move(_ time.Duration, p elements.EventProcessor) error {
+	c.Moves++
+	c.Roll = p.Rand().Random(0, 1000)
+	if c.Lives > 0 && c.Moves == c.Lives {
+		return p.ProcessEvent(event.Event{Type: "lose", From: c.ID})
+	}
+	return nil
+}
+
+func init() {
+	elements.GenElements[counterType] = func() elements.Element { return &counter{} }
+}
+
+// gameRoom sends players who lose to the lobby, replay has no lobby just like the demo
+func gameRoom(lives int, lobby func() *Room, opts ...RoomOption) *Room {
+	opts = append(opts,
+		WithJoinPolicy(func(_ map[int]elements.Playable, _ map[int]struct{}, r *Room) (int, error) {
+			id := r.NewID()
+			r.NewElement(&counter{ID: id, Lives: lives})
+			return id, nil
+		}),
+		WithEventHandler("lose", func(e event.Event, r *Room) error {
+			return r.Transfer(e.From, lobby())
+		}),
+	)
+	return NewBasicRoom(0, "game", []elements.Element{&counter{ID: 0}}, opts...)
+}
+
+func lobbyRoom() *Room {
+	return NewBasicRoom(0, "lobby", nil, WithJoinPolicy(func(_ map[int]elements.Playable, _ map[int]struct{}, r *Room) (int, error) {
+		id := r.NewID()
+		r.NewElement(&counter{ID: id})
+		return id, nil
+	}))
+}
+
+// serve runs room behind a websocket server, connect dials it and reads until the connection is closed
+func serve(t *testing.T, room *Room) (connect func() func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: event.Subprotocols()})
+		if err != nil {
+			return
+		}
+		_ = room.Run(c)
+	}))
+	t.Cleanup(srv.Close)
+	return func() func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				if _, _, err := c.Read(ctx); err != nil {
+					return
+				}
+			}
+		}()
+		return func() {
+			_ = c.Close(websocket.StatusNormalClosure, "")
+			cancel()
+		}
+	}
+}
+
+func record(t *testing.T, lives int, play time.Duration) []byte {
+	lobby := lobbyRoom()
+	lobby.Start()
+	defer lobby.Stop()
+	var buf bytes.Buffer
+	game := gameRoom(lives, func() *Room { return lobby }, WithRecorder(&buf), WithTickRate(100), WithSeed(1))
+	game.Rand().Random(0, 10) // recording starts in the middle of the random sequence
+	game.Start()
+	connect := serve(t, game)
+	disconnect := connect()
+	defer disconnect()
+	time.Sleep(play)
+	game.Stop()
+	<-game.Done()
+	return buf.Bytes()
+}
+
+func replay(t *testing.T, rec []byte) *ReplayReport {
+	rep, err := Replay(bytes.NewReader(rec), func(string) *Room {
+		return gameRoom(0, func() *Room { return nil })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.Complete || rep.Checkpoints == 0 {
+		t.Fatalf("recording is not replayed fully: %+v", rep)
+	}
+	for _, d := range rep.Divergences {
+		t.Errorf("divergence at tick %d: element %d %s %s", d.Tick, d.Element, d.Problem, d.Patch)
+	}
+	return rep
+}
+
+func TestReplayReproducesRecording(t *testing.T) {
+	rep := replay(t, record(t, 0, 1500*time.Millisecond))
+	if rep.Ticks < 100 {
+		t.Errorf("replayed %d ticks, want more than a second of them", rep.Ticks)
+	}
+}
+
+func TestReplayReproducesTransferOnLoss(t *testing.T) {
+	rec := record(t, 30, 1500*time.Millisecond)
+	if !bytes.Contains(gunzip(t, rec), []byte(`"Kind":"left"`)) {
+		t.Fatal("transfer on loss is not recorded")
+	}
+	replay(t, rec)
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestReplayRejectsGarbage(t *testing.T) {
+	if _, err := Replay(strings.NewReader("not a recording"), nil); err == nil {
+		t.Fatal("garbage is replayed")
+	}
+}
+
+// TestSeededRoomsAreTheSame steps two rooms of the same seed without the clock
+func TestSeededRoomsAreTheSame(t *testing.T) {
+	state := func() []byte {
+		room := gameRoom(0, nil, WithSeed(3), WithClock(time.Unix(0, 0)))
+		for i := 0; i < 50; i++ {
+			room.Step(room.TickDuration())
+		}
+		st, err := room.GetState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	if a, b := state(), state(); !bytes.Equal(a, b) {
+		t.Fatalf("rooms of the same seed differ:\n%s\n%s", a, b)
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/arovesto/gio/event"
+)
+
+const DefaultResumeGrace = 30 * time.Second
+
+var (
+	ResumeFailed = errors.New("session can't be resumed")
+	BadToken     = errors.New("bad resume token")
+)
+
+// resumeClaim is what resume token says: element of the player in the room he was in
+type resumeClaim struct {
+	Room  string
+	ID    int
+	Nonce string // the token is valid only for the last disconnection of the player
+}
+
+// resumer issues and checks resume tokens, tokens are signed so clients can't claim elements of others
+type resumer struct {
+	secret []byte
+	grace  time.Duration
+}
+
+func newResumer(secret []byte, grace time.Duration) (*resumer, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	if grace <= 0 {
+		grace = DefaultResumeGrace
+	}
+	return &resumer{secret: secret, grace: grace}, nil
+}
+
+func (rs *resumer) sign(data []byte) []byte {
+	m := hmac.New(sha256.New, rs.secret)
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+func (rs *resumer) issue(c resumeClaim) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(data) + "." + enc.EncodeToString(rs.sign(data)), nil
+}
+
+func (rs *resumer) verify(token string) (c resumeClaim, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return c, BadToken
+	}
+	enc := base64.RawURLEncoding
+	data, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return c, BadToken
+	}
+	sig, err := enc.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, rs.sign(data)) {
+		return c, BadToken
+	}
+	if err = json.Unmarshal(data, &c); err != nil {
+		return c, BadToken
+	}
+	return c, nil
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// awayPlayer is a disconnected player whose element is kept frozen until he is back or time is out
+type awayPlayer struct {
+	nonce   string
+	until   time.Time
+	movedTo *Room // player was transferred while away, his element is not here anymore
+}
+
+// assignment tells the client his element and the token to get it back after reconnection
+func (s *Room) assignment(p *player, me int) (event.Event, error) {
+	a := event.Assign{ID: me}
+	rs := p.session.resume
+	if rs != nil && s.key != "" {
+		nonce, err := newNonce()
+		if err != nil {
+			return event.Event{}, err
+		}
+		if a.Token, err = rs.issue(resumeClaim{Room: s.key, ID: me, Nonce: nonce}); err != nil {
+			return event.Event{}, err
+		}
+		p.nonce = nonce
+	}
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return event.Event{}, err
+	}
+	return event.Event{Type: "assign", Payload: payload}, nil
+}
+
+// keepAway freezes element of the disconnected player, false means player can't come back
+func (s *Room) keepAway(me int, p *player) bool {
+	rs := p.session.resume
+	if rs == nil || p.nonce == "" || p.session.forfeited() || s.CurrentState() != Running {
+		return false
+	}
+	s.clientsLock.Lock()
+	s.away[me] = &awayPlayer{nonce: p.nonce, until: s.Now().Add(rs.grace)}
+	s.clientsLock.Unlock()
+	s.rec.away(s, me, true)
+	return true
+}
+
+// reattach gives the session element of the away player, next is not nil if player was transferred meanwhile
+func (s *Room) reattach(ss *session, c resumeClaim) (next *Room, err error) {
+	a, ok := s.away[c.ID]
+	if c.Room != s.key || !ok || a.nonce != c.Nonce {
+		return nil, ResumeFailed
+	}
+	s.clientsLock.Lock()
+	delete(s.away, c.ID)
+	s.clientsLock.Unlock()
+	s.rec.away(s, c.ID, false)
+	if a.movedTo != nil {
+		return a.movedTo, nil
+	}
+	if _, ok := s.players[c.ID]; !ok {
+		return nil, ResumeFailed
+	}
+	return nil, s.attach(ss, c.ID)
+}
+
+// expireAway deletes elements of players who haven't come back in time
+func (s *Room) expireAway(now time.Time) {
+	for id, a := range s.away {
+		if now.Before(a.until) {
+			continue
+		}
+		s.clientsLock.Lock()
+		delete(s.away, id)
+		if !s.playing() {
+			s.emptySince = time.Now()
+		}
+		s.clientsLock.Unlock()
+		s.rec.away(s, id, false)
+		if a.movedTo == nil {
+			s.DeleteElement(id)
+		}
+	}
+}
+
+// isAway reports frozen elements of disconnected players
+func (s *Room) isAway(id int) bool {
+	a, ok := s.away[id]
+	return ok && a.movedTo == nil
+}
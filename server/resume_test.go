@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/arovesto/gio/config"
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	rs, err := newResumer([]byte("secret"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim := resumeClaim{Room: "lobby", ID: 7, Nonce: "n"}
+	token, err := rs.issue(claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rs.verify(token)
+	if err != nil || got != claim {
+		t.Fatalf("verified %+v %v, want %+v", got, err, claim)
+	}
+
+	other, _ := newResumer([]byte("another secret"), time.Minute)
+	forged, _ := other.issue(resumeClaim{Room: "lobby", ID: 8, Nonce: "n"})
+	parts := strings.Split(token, ".")
+	tampered := strings.Split(forged, ".")[0] + "." + parts[1]
+	for _, bad := range []string{"", "garbage", token + ".x", forged, tampered, parts[0] + ".AAAA"} {
+		if _, err := rs.verify(bad); !errors.Is(err, BadToken) {
+			t.Errorf("token %q is %v", bad, err)
+		}
+	}
+}
+
+func TestExpireAway(t *testing.T) {
+	room := lobbyRoom()
+	id := room.NewID()
+	room.NewElement(&counter{ID: id})
+	until := time.Unix(100, 0)
+	room.away[id] = &awayPlayer{nonce: "n", until: until}
+	room.expireAway(until.Add(-time.Second))
+	if !room.isAway(id) {
+		t.Fatal("away player is expired before time")
+	}
+	room.expireAway(until)
+	if room.isAway(id) || room.GetElement(id) != nil {
+		t.Fatal("away player is kept after time")
+	}
+	if _, err := room.reattach(&session{}, resumeClaim{ID: id, Nonce: "n"}); !errors.Is(err, ResumeFailed) {
+		t.Fatalf("resume of the expired player is %v", err)
+	}
+}
+
+// resuming is a server with resume and the started lobby registered as "lobby"
+func resuming(t *testing.T) (*Server, string) {
+	srv, err := NewServer(config.Default(), SpectateByQuery(func(rooms map[string]*Room) (*Room, error) {
+		return rooms["lobby"], nil
+	}), WithResume([]byte("secret"), time.Minute), WithServerLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby := lobbyRoom()
+	if err := srv.Rooms().Register("lobby", lobby); err != nil {
+		t.Fatal(err)
+	}
+	lobby.Start()
+	hs := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		hs.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	})
+	return srv, "ws" + strings.TrimPrefix(hs.URL, "http") + "/socket"
+}
+
+// assigned connects to url and waits for the element the client is given
+func assigned(t *testing.T, url string) (*websocket.Conn, event.Assign) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, data, err := c.Read(ctx)
+		if err != nil {
+			t.Fatalf("no assign event: %v", err)
+		}
+		e, err := event.ParseEvent(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := event.Unpack(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range b.Events {
+			if e.Type != "assign" {
+				continue
+			}
+			var a event.Assign
+			if err := json.Unmarshal(e.Payload, &a); err != nil {
+				t.Fatal(err)
+			}
+			// the rest of the frames are not read
+			go func() {
+				for {
+					if _, _, err := c.Read(context.Background()); err != nil {
+						return
+					}
+				}
+			}()
+			return c, a
+		}
+	}
+}
+
+func TestResumeGetsTheFrozenElement(t *testing.T) {
+	srv, url := resuming(t)
+	lobby, _ := srv.Rooms().Lookup("lobby")
+	c, a := assigned(t, url)
+	if a.Token == "" {
+		t.Fatal("no resume token")
+	}
+	_ = c.Close(websocket.StatusGoingAway, "")
+	eventually(t, lobby, "player is not away", func(r *Room) bool { return r.isAway(a.ID) && len(r.clients) == 0 })
+
+	c, back := assigned(t, url+"?resume="+a.Token)
+	defer c.Close(websocket.StatusNormalClosure, "")
+	if back.ID != a.ID {
+		t.Fatalf("resumed element %d, want %d", back.ID, a.ID)
+	}
+	eventually(t, lobby, "player is not back", func(r *Room) bool { return !r.isAway(a.ID) && r.hasPlayer(a.ID) })
+
+	// the token is good for one disconnection only
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c2, _, err := websocket.Dial(ctx, url+"?resume="+a.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, data, err := c2.Read(ctx)
+		if err != nil {
+			break
+		}
+		if strings.Contains(string(data), `"assign"`) {
+			t.Fatal("used token is accepted again")
+		}
+	}
+}
+
+func TestResumeFollowsTransferWhileAway(t *testing.T) {
+	srv, url := resuming(t)
+	lobby, _ := srv.Rooms().Lookup("lobby")
+	game := lobbyRoom()
+	if err := srv.Rooms().Register("game", game); err != nil {
+		t.Fatal(err)
+	}
+	c, a := assigned(t, url)
+	_ = c.Close(websocket.StatusGoingAway, "")
+	eventually(t, lobby, "player is not away", func(r *Room) bool { return r.isAway(a.ID) })
+	_ = lobby.Do(func(r *Room) {
+		if err := r.Transfer(a.ID, game); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if lobby.PlayersCount() != 0 {
+		t.Fatal("transferred away player is counted")
+	}
+
+	c, _ = assigned(t, url+"?resume="+a.Token)
+	defer c.Close(websocket.StatusNormalClosure, "")
+	eventually(t, game, "player hasn't followed the transfer", players(1))
+	eventually(t, lobby, "player is still in the lobby", func(r *Room) bool { return len(r.away) == 0 && len(r.clients) == 0 })
+}
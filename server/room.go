@@ -6,9 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"nhooyr.io/websocket"
@@ -16,31 +16,61 @@ import (
 	"github.com/arovesto/gio/canvas"
 	"github.com/arovesto/gio/elements"
 	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
+	"github.com/arovesto/gio/math"
+	"github.com/arovesto/gio/storage"
 )
 
 var (
 	EntityNotFound = errors.New("entity not found")
 	RoomFull       = errors.New("room full")
+	RoomStopped    = errors.New("room stopped")
+	// PlayerBusy is returned by Transfer when session of the player hasn't taken the previous transfer yet
+	PlayerBusy = errors.New("player is busy")
 )
 
+const layers = 10
+
+// room lifecycle: Created -> Running -> Draining -> Stopped, Web is used for the client side copy of the room
 const (
-	layers  = 10
-	Stopped = iota
+	Created = iota
 	Running
+	Draining
+	Stopped
 	Web
 )
 
 var readAtMostEvents = 100
 
-// TODO replace this with function field of a room, it is seams better
-var PlayerChoiceFunctions = map[string]func(playable map[int]elements.Playable, assigned map[int]struct{}, r *Room) (int, error){} // what element of room should be used on new connection
+const defaultStopReason = "room stopped"
+
+const (
+	DefaultTickRate   = 60
+	DefaultMaxCatchUp = 5
+	// DefaultPersistInterval is how often persistent rooms are saved
+	DefaultPersistInterval = time.Minute
+)
+
+// JoinPolicy chooses what element of room should be used on new connection
+type JoinPolicy func(playable map[int]elements.Playable, assigned map[int]struct{}, r *Room) (int, error)
+
+// EventHandler processes custom events of the room
+type EventHandler func(e event.Event, r *Room) error
 
-// TODO replace this with local map in Room thing
-var EventsProcessors = map[string]map[string]func(e event.Event, r *Room) error{} // use this map as event blablabla
+// Deprecated: use WithJoinPolicy, this map is used only for rooms without own join policy
+var PlayerChoiceFunctions = map[string]func(playable map[int]elements.Playable, assigned map[int]struct{}, r *Room) (int, error){}
+
+// Deprecated: use WithEventHandler, this map is used only for events room has no own handler for
+var EventsProcessors = map[string]map[string]func(e event.Event, r *Room) error{}
 
 type player struct {
-	transfer chan *Room
-	c        *websocket.Conn
+	session *session
+	pending []event.Event    // events of the current tick, sent as one batch
+	known   map[int][]byte   // last state of every element this client got
+	dropped uint64           // messages dropped by the send queue, more of them means client is behind
+	visible map[int]struct{} // elements client knows about, nil if room sends him everything
+	ack     uint32           // the last input processed
+	nonce   string           // of the resume token client has, empty if he can't resume
 }
 
 type RawElement struct {
@@ -49,10 +79,25 @@ type RawElement struct {
 }
 
 type Room struct {
+	rejected uint64 // events of clients which were dropped, first for atomic alignment
+
 	clientsLock sync.RWMutex
-	clients     map[int]player
-	events      chan event.Event
+	clients     map[int]*player
+	away        map[int]*awayPlayer // disconnected players who may come back
+	watchers    int                 // spectators ever joined, to give them keys
+	emptySince  time.Time
+	commands    chan func()
 	done        chan struct{}
+	stopped     chan struct{}
+
+	idleLock   sync.Mutex
+	stateLock  sync.Mutex
+	stopOnce   sync.Once
+	stopReason string
+	hooks      []StateHook
+
+	registry *Registry
+	key      string
 
 	State       int          `json:"-"`
 	ID          int          `json:"id"`
@@ -67,30 +112,209 @@ type Room struct {
 	collidable  map[int]elements.Collidable
 	drawOrder   []map[int]elements.Drawable
 	toDelete    map[int]struct{}
+	order       []int // ids of every element ascending, so every run goes through them the same way
 	oneTickDiff map[int][]byte
+	baselines   map[int][]byte // last full state of every element, patches are applied to it
 
 	currentID int
+
+	tickRate   int
+	maxCatchUp int
+	tick       uint64
+	epoch      time.Time // room time of tick zero, zero for the rooms which use the wall clock
+	fixedEpoch bool      // epoch is given by WithClock, it is not moved when room starts
+	rng        *math.Rand
+
+	view math.Vector
+
+	maxRewind time.Duration
+	history   []colliderFrame   // colliders of the recent ticks, the oldest first
+	seen      map[int]time.Time // room time players were shown when they made their last input
+
+	joinPolicy   JoinPolicy
+	handlers     map[string]EventHandler
+	fallback     EventHandler
+	clientEvents map[string]bool // event types clients may send
+
+	minPlayers int
+	maxPlayers int // zero is not limited
+
+	base   logging.Logger // given by WithLogger or by the registry
+	ownLog bool           // base is given by WithLogger, registry must not replace it
+	log    logging.Logger // base with fields of the room
+	meter  *meter         // nil if room is not registered in the registry with metrics
+
+	persistent   bool
+	persistEvery time.Duration
+	storage      storage.Storage // nil if room is not saved
+	saves        chan []byte     // snapshots for the saver goroutine
+	saverDone    chan struct{}
+
+	rec      *recorder        // nil if room is not recorded
+	stepping bool             // room is in the middle of the tick
+	left     map[int]struct{} // replay only, players the recorded room transferred during this tick
 }
 
-func NewBasicRoom(id int, tp string, elms []elements.Element) *Room {
-	r := &Room{}
+type RoomOption func(r *Room)
+
+// StateHook is called after room moved from one state to another
+type StateHook func(r *Room, from, to int)
+
+// WithTickRate sets how many simulation steps per second the room makes
+func WithTickRate(tps int) RoomOption {
+	return func(r *Room) {
+		if tps > 0 {
+			r.tickRate = tps
+		}
+	}
+}
+
+// WithMaxCatchUp limits how many steps room makes in a row when it is late, the rest of the lag is dropped
+func WithMaxCatchUp(steps int) RoomOption {
+	return func(r *Room) {
+		if steps > 0 {
+			r.maxCatchUp = steps
+		}
+	}
+}
+
+// WithStateHook adds a hook called on every room state change
+func WithStateHook(h StateHook) RoomOption {
+	return func(r *Room) {
+		r.hooks = append(r.hooks, h)
+	}
+}
+
+// WithJoinPolicy sets how new connections are given their elements
+func WithJoinPolicy(p JoinPolicy) RoomOption {
+	return func(r *Room) {
+		r.joinPolicy = p
+	}
+}
+
+// WithEventHandler sets handler of the custom event type
+func WithEventHandler(tp string, h EventHandler) RoomOption {
+	return func(r *Room) {
+		if r.handlers == nil {
+			r.handlers = map[string]EventHandler{}
+		}
+		r.handlers[tp] = h
+	}
+}
+
+// WithFallbackHandler sets handler for custom events nobody else processes
+func WithFallbackHandler(h EventHandler) RoomOption {
+	return func(r *Room) {
+		r.fallback = h
+	}
+}
+
+// WithClientEvents sets which event types clients may send to the room, only "input" by default
+func WithClientEvents(types ...string) RoomOption {
+	return func(r *Room) {
+		r.clientEvents = map[string]bool{}
+		for _, t := range types {
+			r.clientEvents[t] = true
+		}
+	}
+}
+
+// WithPlayers declares how many players the room is for, players over max get RoomFull on join.
+// Matchmaker starts the room when there are min players waiting for it
+func WithPlayers(min, max int) RoomOption {
+	return func(r *Room) {
+		r.minPlayers, r.maxPlayers = min, max
+	}
+}
+
+// WithLogger sets logger of the room, otherwise it is the logger of the registry room is registered in
+func WithLogger(l logging.Logger) RoomOption {
+	return func(r *Room) {
+		r.base, r.ownLog = l, true
+	}
+}
+
+// WithSeed seeds random source of the room, rooms seeded the same way and fed the same events make the same states.
+// Otherwise the seed is random
+func WithSeed(seed int64) RoomOption {
+	return func(r *Room) {
+		r.rng = math.NewRand(seed)
+	}
+}
+
+// WithClock sets room time of tick zero, room time moves only with ticks. Otherwise it is the time room starts
+func WithClock(start time.Time) RoomOption {
+	return func(r *Room) {
+		r.epoch, r.fixedEpoch = start, true
+	}
+}
+
+func NewBasicRoom(id int, tp string, elms []elements.Element, opts ...RoomOption) *Room {
+	r := &Room{maxCatchUp: DefaultMaxCatchUp, clientEvents: map[string]bool{"input": true}}
+	for _, o := range opts {
+		o(r)
+	}
+	if r.epoch.IsZero() {
+		r.epoch = time.Now()
+	}
 	r.init(id, tp, elms)
 	return r
 }
 
+// SetLogger sets logger of the room made without NewBasicRoom, such as the client side copy
+func (s *Room) SetLogger(l logging.Logger) {
+	s.base, s.ownLog = l, true
+	s.log = nil
+}
+
+// Logger is the logger of the room, every message has room type and key if room is registered
+func (s *Room) Logger() logging.Logger {
+	if s.log == nil {
+		base := s.base
+		if base == nil {
+			base = logging.Default()
+		}
+		if s.key == "" {
+			s.log = base.With(logging.RoomType(s.Type))
+		} else {
+			s.log = base.With(logging.RoomKey(s.key), logging.RoomType(s.Type))
+		}
+	}
+	return s.log
+}
+
+// logAt is the logger of the room with the current tick and fields of element e, if it is not nil.
+// Tick doesn't split repeated messages, so overloads of every tick are still counted as one
+func (s *Room) logAt(e elements.Element) logging.Logger {
+	l := s.Logger()
+	if e != nil {
+		l = l.With(logging.Element(e.GetID()), logging.ElementType(e.GetType()))
+	}
+	return logging.At(l, logging.Tick(s.tick))
+}
+
 func (s *Room) init(id int, tp string, elms []elements.Element) {
 	s.elements = map[int]elements.Element{}
 	s.movable = map[int]elements.Movable{}
 	s.players = map[int]elements.Playable{}
 	s.oneTickDiff = map[int][]byte{}
+	s.baselines = map[int][]byte{}
 	s.collidable = map[int]elements.Collidable{}
 	s.toDelete = map[int]struct{}{}
+	s.order = nil
+	s.seen = map[int]time.Time{}
 	s.drawOrder = make([]map[int]elements.Drawable, layers)
 	s.ID = id
 	s.Type = tp
 	s.done = make(chan struct{})
-	s.events = make(chan event.Event, readAtMostEvents)
-	s.clients = map[int]player{}
+	s.stopped = make(chan struct{})
+	s.commands = make(chan func(), readAtMostEvents)
+	s.clients = map[int]*player{}
+	s.away = map[int]*awayPlayer{}
+	s.emptySince = time.Now()
+
+	s.log = nil
+	s.Logger()
 
 	for _, el := range elms {
 		s.newTrueElement(el)
@@ -105,59 +329,389 @@ func (s *Room) GetElements() map[int]elements.Element {
 	return s.elements
 }
 
+// Registry is a registry the room is registered in, nil if none
+func (s *Room) Registry() *Registry {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.registry
+}
+
+// Key is a key of the room in its registry
+func (s *Room) Key() string {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.key
+}
+
+func (s *Room) setRegistry(r *Registry, key string) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.registry = r
+	s.key = key
+}
+
+// ClientsCount is a number of connected clients
+func (s *Room) ClientsCount() int {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	return len(s.clients)
+}
+
+// EmptySince reports when the last player left the room and is the room empty now, spectators don't count
+func (s *Room) EmptySince() (time.Time, bool) {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	return s.emptySince, !s.playing()
+}
+
+// playing reports players in the room, away ones included, clientsLock must be held
+func (s *Room) playing() bool {
+	for id := range s.clients {
+		if !spectator(id) {
+			return true
+		}
+	}
+	return len(s.away) != 0
+}
+
+// PlayersCount is how many clients play in the room, spectators are not counted and away players are
+// unless they were transferred to another room meanwhile
+func (s *Room) PlayersCount() (n int) {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	for id := range s.clients {
+		if !spectator(id) {
+			n++
+		}
+	}
+	for _, a := range s.away {
+		if a.movedTo == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Capacity is how many players the room is for, zero max is not limited
+func (s *Room) Capacity() (min, max int) {
+	return s.minPlayers, s.maxPlayers
+}
+
+func (s *Room) hasPlayer(id int) bool {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	_, ok := s.clients[id]
+	return ok
+}
+
+// Players are ids of the playable elements in ascending order
 func (s *Room) Players() (r []int) {
-	for id := range s.players {
-		r = append(r, id)
+	for _, id := range s.order {
+		if _, ok := s.players[id]; ok {
+			r = append(r, id)
+		}
 	}
 	return
 }
 
+// Start runs the tick loop, it does nothing if room is already started or stopped
 func (s *Room) Start() {
-	go func() {
-		now := time.Now()
-		lastUpdate := now
-		for {
-			// TODO separate processEvents from Update, so all delta became consistent
-			n := time.Now()
-			if n.Sub(lastUpdate) > 16*time.Millisecond {
-				st := time.Now()
-				s.Update(n.Sub(lastUpdate))
-				s.processEvents()
-				lastUpdate = n
-				if time.Since(st) > 16*time.Millisecond {
-					log.Println("overload! main cycle can't keep up", time.Since(st))
+	s.idleLock.Lock()
+	defer s.idleLock.Unlock()
+	s.stateLock.Lock()
+	if s.State != Created {
+		s.stateLock.Unlock()
+		return
+	}
+	s.State = Running
+	s.stateLock.Unlock()
+	if !s.fixedEpoch && !s.epoch.IsZero() {
+		s.epoch = time.Now().Add(-time.Duration(s.tick) * s.TickDuration())
+	}
+	s.runHooks(Created, Running)
+
+	go s.loop()
+}
+
+// Stop halts the room with the default reason
+func (s *Room) Stop() {
+	s.StopWithReason(defaultStopReason)
+}
+
+// StopWithReason halts the tick loop and closes every client connection with given reason.
+// Room is Draining until the loop exits and Stopped after, use Done to wait for it
+func (s *Room) StopWithReason(reason string) {
+	s.stopOnce.Do(func() {
+		s.stopReason = reason
+		prev := s.setState(Draining)
+		close(s.done)
+		if prev != Running {
+			// nobody runs the loop, so nobody would finish the job
+			s.shutdown()
+		}
+	})
+}
+
+// Do runs f by the room goroutine between ticks and waits for it to finish.
+// Everything what changes the room from other goroutines should be done this way
+func (s *Room) Do(f func(r *Room)) error {
+	return s.do(func() {
+		f(s)
+	})
+}
+
+// do runs f by the room goroutine, room that is not started yet runs f right away
+func (s *Room) do(f func()) error {
+	s.idleLock.Lock()
+	switch s.CurrentState() {
+	case Created:
+		defer s.idleLock.Unlock()
+		f()
+		return nil
+	case Running:
+		s.idleLock.Unlock()
+	default:
+		s.idleLock.Unlock()
+		return RoomStopped
+	}
+
+	finished := make(chan struct{})
+	select {
+	case s.commands <- func() {
+		f()
+		close(finished)
+	}:
+	case <-s.done:
+		return RoomStopped
+	}
+	select {
+	case <-finished:
+		return nil
+	case <-s.done:
+		return RoomStopped
+	}
+}
+
+// Done is closed when room is fully stopped
+func (s *Room) Done() <-chan struct{} {
+	return s.stopped
+}
+
+// CurrentState is a safe way to get room State from other goroutines
+func (s *Room) CurrentState() int {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.State
+}
+
+func (s *Room) setState(to int) (from int) {
+	s.stateLock.Lock()
+	from = s.State
+	s.State = to
+	s.stateLock.Unlock()
+	if from != to {
+		s.runHooks(from, to)
+	}
+	return from
+}
+
+func (s *Room) runHooks(from, to int) {
+	for _, h := range s.hooks {
+		h(s, from, to)
+	}
+}
+
+func (s *Room) shutdown() {
+	// before clients are gone, so their elements are known and not saved
+	s.persistLast()
+	s.rec.finish(s)
+	s.clientsLock.Lock()
+	for id, p := range s.clients {
+		p.session.kick(websocket.StatusGoingAway, s.stopReason)
+		delete(s.clients, id)
+	}
+	s.clientsLock.Unlock()
+	s.setState(Stopped)
+	close(s.stopped)
+}
+
+// inherit sets tick rate, logger and recorder of the registry to the room which has no own ones,
+// makes metrics of the room and restores persistent room, it does nothing if room is started
+func (s *Room) inherit(r *Registry) {
+	s.idleLock.Lock()
+	defer s.idleLock.Unlock()
+	if s.CurrentState() != Created {
+		return
+	}
+	if s.tickRate <= 0 {
+		s.tickRate = r.tps
+	}
+	if !s.ownLog && r.log != nil {
+		s.base = r.log
+	}
+	s.meter = newMeter(r.metrics, s.key)
+	s.log = nil
+	s.Logger()
+	if s.persistent && r.storage != nil {
+		s.storage = r.storage
+		if s.persistEvery <= 0 {
+			s.persistEvery = r.persistEvery
+		}
+		if s.persistEvery <= 0 {
+			s.persistEvery = DefaultPersistInterval
+		}
+		s.restore()
+	}
+	if s.rec == nil && r.recordDir != "" {
+		rec, err := recordTo(r.recordDir, s.key)
+		if err != nil {
+			s.Logger().Error("failed to start recording", logging.Err(err))
+		}
+		s.rec = rec
+	}
+}
+
+// TickDuration is a fixed delta passed to every Movable on each tick
+func (s *Room) TickDuration() time.Duration {
+	if s.tickRate <= 0 {
+		return time.Second / DefaultTickRate
+	}
+	return time.Second / time.Duration(s.tickRate)
+}
+
+// CurrentTick is a number of simulation steps made by the room. It is not synchronized, so call it from the
+// room goroutine only: from elements, handlers or Do
+func (s *Room) CurrentTick() uint64 {
+	return s.tick
+}
+
+// Now is room time of the current tick, it moves by TickDuration with every tick, so replays and tests get the same
+// time as the room had. Rooms not made by NewBasicRoom, such as the client side copy, use the wall clock
+func (s *Room) Now() time.Time {
+	if s.epoch.IsZero() {
+		return time.Now()
+	}
+	return s.epoch.Add(time.Duration(s.tick) * s.TickDuration())
+}
+
+// Rand is random source of the room, see WithSeed
+func (s *Room) Rand() *math.Rand {
+	if s.rng == nil {
+		s.rng = math.NewRand(time.Now().UnixNano())
+	}
+	return s.rng
+}
+
+// loop is a fixed timestep scheduler: real time is accumulated and spent in equal steps,
+// if room is late for more than maxCatchUp steps the rest is dropped
+func (s *Room) loop() {
+	step := s.TickDuration()
+	maxCatchUp := s.maxCatchUp
+	if maxCatchUp <= 0 {
+		maxCatchUp = DefaultMaxCatchUp
+	}
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	var saveC <-chan time.Time // never fires for rooms which are not saved
+	if s.storage != nil {
+		saver := time.NewTicker(s.persistEvery)
+		defer saver.Stop()
+		saveC = saver.C
+		s.startSaver()
+	}
+	s.rec.start(s)
+
+	last := time.Now()
+	var acc time.Duration
+	for {
+		select {
+		case <-s.done:
+			s.shutdown()
+			return
+		case cmd := <-s.commands:
+			cmd()
+		case <-saveC:
+			s.persist()
+		case n := <-ticker.C:
+			acc += n.Sub(last)
+			last = n
+			for steps := 0; acc >= step; steps++ {
+				if steps == maxCatchUp {
+					s.meter.overloaded()
+					s.logAt(nil).Repeated(logging.WarnLevel, "overload! main cycle can't keep up, dropping steps", logging.Duration(acc))
+					acc = 0
+					break
 				}
+				s.Step(step)
+				acc -= step
 			}
-			time.Sleep(time.Millisecond)
-			now = n
 		}
-	}()
+	}
+}
 
-	s.State = Running
+// Step makes one simulation step: events are processed first, then everything is moved and collided,
+// after that changes are sent to the clients
+func (s *Room) Step(delta time.Duration) {
+	start := time.Now()
+	defer s.meter.measure(s, start)
+	s.tick++
+	s.stepping = true
+	s.snapshot()
+	s.expireAway(s.Now())
+	s.processEvents()
+	s.rec.mute(true)
+	s.Update(delta)
+	s.flush()
+	s.rec.mute(false)
+	s.recordColliders(s.Now())
+	s.sendBatches()
+	s.stepping = false
+	s.rec.checkpoint(s)
 }
 
-func (s *Room) Update(delta time.Duration) {
+// snapshot stores states of every movable, so changes made during the tick can be found
+func (s *Room) snapshot() {
 	s.toDelete = map[int]struct{}{}
 	s.oneTickDiff = map[int][]byte{}
 
 	for _, e := range s.movable {
 		st, err := e.GetState()
 		if err != nil {
-			log.Println("error on get state", e.GetID(), e.GetType(), err)
+			s.logAt(e).Error("failed to get state", logging.Err(err))
 			continue
 		}
 		s.oneTickDiff[e.GetID()] = st
+	}
+}
+
+// Update moves and collides elements in order of their ids, elements added meanwhile wait for the next tick
+func (s *Room) Update(delta time.Duration) {
+	order := append([]int(nil), s.order...)
+	for _, id := range order {
+		e, ok := s.movable[id]
+		if !ok || s.isAway(id) {
+			continue
+		}
 		if err := e.Move(delta, s); err != nil {
-			log.Println("error on move entity", e.GetID(), e.GetType(), err)
+			s.logAt(e).Error("failed to move element", logging.Err(err))
 		}
 	}
 
-	for i1, e1 := range s.collidable {
-		for i2, e2 := range s.collidable {
-			if i1 != i2 {
-				if err := e1.Collide(e2); err != nil {
-					log.Println("collide error", err)
-				}
+	collidable := order[:0]
+	for _, id := range s.order {
+		if _, ok := s.collidable[id]; ok {
+			collidable = append(collidable, id)
+		}
+	}
+	for _, i1 := range collidable {
+		for _, i2 := range collidable {
+			e1, ok1 := s.collidable[i1]
+			e2, ok2 := s.collidable[i2]
+			if i1 == i2 || !ok1 || !ok2 {
+				continue
+			}
+			if err := e1.Collide(e2); err != nil {
+				s.logAt(e1).Error("failed to collide", logging.F("with", i2), logging.Err(err))
 			}
 		}
 	}
@@ -168,62 +722,131 @@ func (s *Room) DeleteElement(id int) {
 	if e == nil {
 		return
 	}
+	s.rec.deleted(s, id)
 
 	delete(s.movable, id)
 	delete(s.collidable, id)
 	delete(s.elements, id)
 	delete(s.players, id)
 	delete(s.drawOrder[getElementLayer(e)], id)
-	if s.State == Running {
-		s.BroadcastEvent(event.Event{Type: "deleted", From: id})
+	delete(s.baselines, id)
+	delete(s.seen, id)
+	if i := sort.SearchInts(s.order, id); i < len(s.order) && s.order[i] == id {
+		s.order = append(s.order[:i], s.order[i+1:]...)
 	}
+	if s.CurrentState() != Running {
+		return
+	}
+	for _, p := range s.clients {
+		if p.sees(id) {
+			p.pending = append(p.pending, event.Event{Type: "deleted", From: id})
+		}
+		delete(p.known, id)
+		delete(p.visible, id)
+	}
+}
+
+// admit checks what session can't: elements client adds must not replace the ones of the room
+func (s *Room) admit(e event.Event) error {
+	if e.Type != "add" {
+		return nil
+	}
+	el, err := elementOf(e.From, e.Payload)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.elements[el.GetID()]; ok {
+		return fmt.Errorf("entity %d on %s: %w", el.GetID(), e.Type, ElementExists)
+	}
+	return nil
 }
 
+// processEvents takes one event of every client in turn, until they are over or the tick budget is spent
 func (s *Room) processEvents() {
-	for i := 0; i < readAtMostEvents; i++ {
-		select {
-		case e := <-s.events:
-			if err := s.ProcessEvent(e); err != nil {
-				log.Println("failed to process event", err)
+	ids := make([]int, 0, len(s.clients))
+	for id := range s.clients {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for budget := readAtMostEvents; budget > 0; {
+		progressed := false
+		for _, id := range ids {
+			p, ok := s.clients[id]
+			if !ok {
+				continue
+			}
+			e, ok := p.session.in.pop()
+			if !ok {
+				continue
+			}
+			err := s.admit(e)
+			if err == nil {
+				err = s.ProcessEvent(e)
+			}
+			if err != nil {
+				s.logAt(nil).Warn("failed to process event", logging.Player(id), logging.F("event", e.Type), logging.Err(err))
 			}
-		default:
-			break
+			progressed = true
+			if budget--; budget == 0 {
+				return
+			}
+		}
+		if !progressed {
+			return
 		}
 	}
-	for _, e := range s.movable {
-		if _, ok := s.toDelete[e.GetID()]; ok {
-			s.DeleteElement(e.GetID())
-			s.BroadcastEvent(event.Event{Type: "deleted", From: e.GetID()})
-			s.clientsLock.RLock()
-			if p, ok := s.clients[e.GetID()]; ok {
-				data, err := json.Marshal(event.Event{Type: "game-over", From: e.GetID()})
-				if err != nil {
-					log.Println("failed to mashal game over event", err)
-				}
-				if err := p.c.Write(context.TODO(), websocket.MessageText, data); err != nil {
-					log.Println("failed to send game over event", err)
-				}
-			}
-			s.clientsLock.RUnlock()
+}
+
+func (s *Room) flush() {
+	deleted := make([]int, 0, len(s.toDelete))
+	for id := range s.toDelete {
+		deleted = append(deleted, id)
+	}
+	sort.Ints(deleted)
+	for _, id := range deleted {
+		s.DeleteElement(id)
+		if err := s.SendEvent(id, event.Event{Type: "game-over", From: id}); err != nil && !errors.Is(err, EntityNotFound) {
+			s.logAt(nil).Error("failed to send game over event", logging.Player(id), logging.Err(err))
+		}
+	}
+	s.updateInterest()
+	s.resync()
+	for _, id := range s.order {
+		e, ok := s.movable[id]
+		if !ok {
+			continue
 		}
 		state, err := e.GetState()
 		if err != nil {
-			log.Println("error getting state", e.GetID(), e.GetType(), err, e)
+			s.logAt(e).Error("failed to get state", logging.Err(err))
+			continue
 		}
 		if old, ok := s.oneTickDiff[e.GetID()]; ok && !bytes.Equal(old, state) {
-			s.BroadcastEvent(event.Event{Type: "update", From: e.GetID(), Payload: state})
+			s.broadcastState(e.GetID(), state)
 		}
 	}
 }
 
-func (s *Room) Stop() {
-	panic("implement me")
+func (s *Room) GetState() ([]byte, error) {
+	return s.stateFor(nil)
 }
 
-func (s *Room) GetState() ([]byte, error) {
+// stateFor is a room state with only the elements client p knows about
+func (s *Room) stateFor(p *player) ([]byte, error) {
+	return s.stateOf(func(id int) bool {
+		return p == nil || p.sees(id)
+	})
+}
+
+// stateOf is a room state with only the elements keep is true for
+func (s *Room) stateOf(keep func(id int) bool) ([]byte, error) {
 	s.RawElements = s.RawElements[:0]
 
-	for _, e := range s.elements {
+	for _, id := range s.order {
+		e := s.elements[id]
+		if !keep(id) {
+			continue
+		}
 		st, err := e.GetState()
 		if err != nil {
 			return nil, err
@@ -256,95 +879,65 @@ func (s *Room) SetState(data []byte) error {
 	return nil
 }
 
+// Run serves the connection until it is closed, it follows the player through transfers to other rooms.
+// Client is limited by DefaultRateLimit
 func (s *Room) Run(c *websocket.Conn) error {
-	if s.State != Running {
-		s.Start()
-	}
-	ctx := context.Background()
-	assigned := map[int]struct{}{}
-	s.clientsLock.RLock()
-	for id, _ := range s.clients {
-		assigned[id] = struct{}{}
-	}
-	s.clientsLock.RUnlock()
-	me, err := PlayerChoiceFunctions[s.Type](s.players, assigned, s)
-	if err != nil {
-		// TODO Mange "Room Full" error appropriately (or not)
-		return err
-	}
-	if _, ok := s.players[me]; !ok {
-		return EntityNotFound
-	}
-	roomData, err := s.GetState()
-	if err != nil {
-		return err
-	}
-	eventData, err := json.Marshal(event.Event{Type: "room", Payload: roomData})
-	if err != nil {
-		return err
-	}
-	if err = c.Write(ctx, websocket.MessageText, eventData); err != nil {
-		return err
-	}
-	eventData, err = json.Marshal(event.Event{Type: "assign", Payload: []byte(fmt.Sprintf("%d", me))})
-	if err != nil {
-		return err
-	}
-	if err = c.Write(ctx, websocket.MessageText, eventData); err != nil {
-		return err
-	}
-	transfer := make(chan *Room, 1)
-	s.clientsLock.Lock()
-	s.clients[me] = player{
-		transfer: transfer,
-		c:        c,
-	}
-	s.clientsLock.Unlock()
+	return s.runWith(c, sessionConfig{limits: DefaultRateLimit}, nil, false)
+}
 
-	// TODO handle "connection closed" appropriately
-	defer func() {
-		s.clientsLock.Lock()
-		delete(s.clients, me)
-		s.clientsLock.Unlock()
-		s.DeleteElement(me)
-	}()
+// Watch serves spectator connection, he gets everything players get but owns no element
+func (s *Room) Watch(c *websocket.Conn) error {
+	return s.runWith(c, sessionConfig{limits: DefaultRateLimit}, nil, true)
+}
 
-	for {
-		_, data, err := c.Read(ctx)
-		if err != nil {
-			return err
-		}
-		ev, err := event.ParseEvent(data)
-		if err != nil {
-			return err
-		}
-		select {
-		case r := <-transfer:
-			return r.Run(c)
-		case s.events <- ev:
-		default:
-			log.Println("overload! event is not pushed", ev)
-		}
-	}
+func (s *Room) runWith(c *websocket.Conn, cfg sessionConfig, claim *resumeClaim, spectate bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ss := newSession(ctx, c, cfg)
+	ss.claim = claim
+	ss.spectator = spectate
+	return ss.run(s)
 }
 
 func (s *Room) ProcessEvent(e event.Event) error {
+	s.rec.event(s, e)
 	switch e.Type {
 	case "update":
 		m, ok := s.elements[e.From]
 		if ok {
+			s.baselines[e.From] = e.Payload
 			return m.SetState(e.Payload)
 		}
 		return fmt.Errorf("entity %d on %s: %w", e.From, e.Type, EntityNotFound)
+	case "patch":
+		m, ok := s.elements[e.From]
+		base, known := s.baselines[e.From]
+		if !ok || !known {
+			return fmt.Errorf("entity %d on %s: %w", e.From, e.Type, EntityNotFound)
+		}
+		doc, err := event.Patch(base, e.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to patch %d: %w", e.From, err)
+		}
+		s.baselines[e.From] = doc
+		return m.SetState(doc)
 	case "input":
 		m, ok := s.players[e.From]
 		if ok {
+			if p, ok := s.clients[e.From]; ok {
+				if e.Seq > p.ack {
+					p.ack = e.Seq
+				}
+			}
+			// kept for the element, not for the client, so replay without clients judges hits the same way
+			if e.Time != 0 {
+				s.seen[e.From] = time.Unix(0, e.Time*int64(time.Millisecond))
+			}
 			return m.SetInput(e.Payload)
 		}
 		return fmt.Errorf("entity %d on %s: %w", e.From, e.Type, EntityNotFound)
 	case "delete":
 		// TODO move this in separate function
-		// TODO add "send to player by id" function
 		// TODO EventProcessor should get "event.Processor" with methods above
 		if _, ok := s.elements[e.From]; ok {
 			s.toDelete[e.From] = struct{}{}
@@ -353,7 +946,11 @@ func (s *Room) ProcessEvent(e event.Event) error {
 			return fmt.Errorf("entity %d on %s: %w", e.From, e.Type, EntityNotFound)
 		}
 	case "add":
-		el := elements.GenElements[e.From]()
+		gen, ok := elements.GenElements[e.From]
+		if !ok {
+			return fmt.Errorf("element type %d on %s: %w", e.From, e.Type, UnknownElement)
+		}
+		el := gen()
 		if err := el.SetState(e.Payload); err != nil {
 			return fmt.Errorf("failed to set el state: %w", err)
 		}
@@ -365,22 +962,28 @@ func (s *Room) ProcessEvent(e event.Event) error {
 	// TODO add "done" event, which is closes up connections, deletes player, etc... on the other side - stop everything
 	// TODO - add some kind of custom processor for "done" event so player can be tossed towards "game over room"?
 	default:
+		if h, ok := s.handlers[e.Type]; ok {
+			return h(e, s)
+		}
 		if evts, ok := EventsProcessors[s.Type]; ok {
 			if f, ok := evts[e.Type]; ok {
 				return f(e, s)
 			}
 		}
+		if s.fallback != nil {
+			return s.fallback(e, s)
+		}
 		return nil
 	}
 }
 
-// TODO implement this better, add toTransfer map and transfer everyone together after update cycle, so no data races on already transferred
+// Transfer moves player to the target room, it has to be called by the room goroutine
 func (s *Room) Transfer(id int, target elements.EventProcessor) error {
-	s.clientsLock.RLock()
-	p, ok := s.clients[id]
-	s.clientsLock.RUnlock()
-	if !ok {
-		return EntityNotFound
+	if _, ok := s.left[id]; ok {
+		// replayed room has neither clients nor rooms to send them to, it just repeats the recorded deletion
+		delete(s.left, id)
+		s.DeleteElement(id)
+		return nil
 	}
 	if target == nil {
 		return errors.New("room is nil")
@@ -389,29 +992,130 @@ func (s *Room) Transfer(id int, target elements.EventProcessor) error {
 	if !ok {
 		return fmt.Errorf("transfer supports only %T not %T", s, target)
 	}
+	if tg == nil {
+		return errors.New("room is nil")
+	}
+	p, ok := s.clients[id]
+	if !ok {
+		if !s.isAway(id) {
+			return EntityNotFound
+		}
+		// he is sent to the target room when he is back
+		s.clientsLock.Lock()
+		s.away[id].movedTo = tg
+		s.clientsLock.Unlock()
+		s.rec.left(s, id)
+		s.DeleteElement(id)
+		return nil
+	}
 	select {
-	case p.transfer <- tg:
+	case p.session.transfer <- tg:
+		s.clientsLock.Lock()
+		delete(s.clients, id)
+		if !s.playing() {
+			s.emptySince = time.Now()
+		}
+		s.clientsLock.Unlock()
+		s.rec.left(s, id)
 		s.DeleteElement(id)
 	default:
-		log.Println("player is busy, cannot transfer")
+		return PlayerBusy
+	}
+	return nil
+}
+
+// Kick disconnects the player, his element is deleted and he can't resume. Away player is removed right away.
+// Like every change of the room it must be done by the room goroutine, see Do
+func (s *Room) Kick(id int, reason string) error {
+	if p, ok := s.clients[id]; ok {
+		p.session.giveUp()
+		p.session.kick(websocket.StatusPolicyViolation, reason)
+		return nil
+	}
+	a, ok := s.away[id]
+	if !ok {
+		return EntityNotFound
+	}
+	s.clientsLock.Lock()
+	delete(s.away, id)
+	if !s.playing() {
+		s.emptySince = time.Now()
+	}
+	s.clientsLock.Unlock()
+	s.rec.away(s, id, false)
+	if a.movedTo == nil {
+		s.DeleteElement(id)
 	}
 	return nil
 }
 
+// BroadcastEvent sends event to every client with the next batch
 func (s *Room) BroadcastEvent(e event.Event) {
-	s.clientsLock.RLock()
-	defer s.clientsLock.RUnlock()
 	for _, p := range s.clients {
-		data, err := json.Marshal(e)
+		p.pending = append(p.pending, e)
+	}
+}
+
+// SendEvent sends event to the client of player id with the next batch
+func (s *Room) SendEvent(id int, e event.Event) error {
+	p, ok := s.clients[id]
+	if !ok {
+		return fmt.Errorf("client %d: %w", id, EntityNotFound)
+	}
+	p.pending = append(p.pending, e)
+	return nil
+}
+
+// sendBatches sends every client all events of the tick in one frame
+func (s *Room) sendBatches() {
+	now := s.Now()
+	for id, p := range s.clients {
+		if len(p.pending) == 0 {
+			continue
+		}
+		reliable := false
+		for _, e := range p.pending {
+			reliable = reliable || !unreliableEvents[e.Type]
+		}
+		b, err := event.NewBatch(s.tick, now, p.pending)
+		p.pending = p.pending[:0]
 		if err != nil {
-			return
+			s.logAt(nil).Error("failed to make batch", logging.Player(id), logging.Err(err))
+			continue
 		}
-		if err := p.c.Write(context.TODO(), websocket.MessageText, data); err != nil {
-			return
+		if err := p.session.sendAs(b, reliable); err != nil {
+			s.logAt(nil).Warn("failed to send batch", logging.Player(id), logging.Err(err))
 		}
 	}
 }
 
+// allowed reports event types clients may send
+func (s *Room) allowed(tp string) bool {
+	return s.clientEvents[tp]
+}
+
+// RejectedEvents is how many events of clients were dropped as malformed or not allowed
+func (s *Room) RejectedEvents() uint64 {
+	return atomic.LoadUint64(&s.rejected)
+}
+
+// reject counts event of a client which was dropped
+func (s *Room) reject() {
+	atomic.AddUint64(&s.rejected, 1)
+	s.meter.drop(droppedRejected)
+}
+
+// QueueStats are stats of send queues of every client of the room
+func (s *Room) QueueStats() map[int]QueueStats {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	res := make(map[int]QueueStats, len(s.clients))
+	for id, p := range s.clients {
+		res[id] = p.session.out.Stats()
+	}
+	return res
+}
+
 func (s *Room) GetID() int {
 	return s.ID
 }
@@ -445,6 +1149,12 @@ func (s *Room) NewID() int {
 }
 
 func (s *Room) newTrueElement(el elements.Element) {
+	if _, ok := s.elements[el.GetID()]; !ok {
+		i := sort.SearchInts(s.order, el.GetID())
+		s.order = append(s.order, 0)
+		copy(s.order[i+1:], s.order[i:])
+		s.order[i] = el.GetID()
+	}
 	s.elements[el.GetID()] = el
 	if p, ok := el.(elements.Playable); ok {
 		s.players[el.GetID()] = p
@@ -467,15 +1177,21 @@ func (s *Room) newTrueElement(el elements.Element) {
 	}
 	st, err := el.GetState()
 	if err != nil {
-		log.Println("failed to get element's state", err)
+		s.logAt(el).Error("failed to get state", logging.Err(err))
 	}
-	if s.State == Running {
+	s.baselines[el.GetID()] = st
+	s.rec.added(s, el, st)
+	// with interest management clients get "add" when element comes into their area
+	if s.CurrentState() == Running && !s.interestManaged() {
+		for _, p := range s.clients {
+			p.known[el.GetID()] = st
+		}
 		s.BroadcastEvent(event.Event{Type: "add", From: el.GetType(), Payload: st})
 	}
 }
 
 func (s *Room) NewElement(el elements.Element) {
-	if s.State == Web {
+	if s.CurrentState() == Web {
 		return
 	}
 	s.newTrueElement(el)
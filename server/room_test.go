@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/logging"
+	"github.com/arovesto/gio/metrics"
+)
+
+func TestPlayersCountSkipsTransferredAwayPlayers(t *testing.T) {
+	room := lobbyRoom()
+	room.away[1] = &awayPlayer{}
+	room.away[2] = &awayPlayer{movedTo: lobbyRoom()}
+	if n := room.PlayersCount(); n != 1 {
+		t.Fatalf("players count is %d, want the away player only", n)
+	}
+}
+
+func TestSpectatorsDontKeepRoomBusy(t *testing.T) {
+	room := lobbyRoom()
+	room.clients[-1] = &player{}
+	if _, empty := room.EmptySince(); !empty {
+		t.Fatal("room of a spectator is not empty")
+	}
+	room.away[1] = &awayPlayer{}
+	if _, empty := room.EmptySince(); empty {
+		t.Fatal("room of an away player is empty")
+	}
+}
+
+// eventually waits for cond checked by the room goroutine
+func eventually(t *testing.T, room *Room, what string, cond func(r *Room) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ok := false
+		if err := room.Do(func(r *Room) { ok = cond(r) }); err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(what)
+}
+
+func players(n int) func(r *Room) bool {
+	return func(r *Room) bool {
+		return r.PlayersCount() == n && len(r.Players()) == n
+	}
+}
+
+func TestDoIsSerialized(t *testing.T) {
+	room := lobbyRoom()
+	room.Start()
+	defer room.Stop()
+	n := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := room.Do(func(*Room) { n++ }); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n != 1000 {
+		t.Fatalf("%d commands are done, want 1000", n)
+	}
+}
+
+func TestDoFailsOnStoppedRoom(t *testing.T) {
+	room := lobbyRoom()
+	room.Start()
+	room.Stop()
+	<-room.Done()
+	if err := room.Do(func(*Room) {}); !errors.Is(err, RoomStopped) {
+		t.Fatalf("command of the stopped room is %v", err)
+	}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	room := lobbyRoom()
+	room.Start()
+	defer room.Stop()
+	connect := serve(t, room)
+	first, second := connect(), connect()
+	eventually(t, room, "players haven't joined", players(2))
+	first()
+	eventually(t, room, "player hasn't left", players(1))
+	second()
+	eventually(t, room, "room isn't empty", func(r *Room) bool {
+		_, empty := r.EmptySince()
+		return empty && len(r.elements) == 0
+	})
+}
+
+func TestTransferOnLoss(t *testing.T) {
+	lobby := lobbyRoom()
+	lobby.Start()
+	defer lobby.Stop()
+	game := gameRoom(10, func() *Room { return lobby })
+	game.Start()
+	defer game.Stop()
+	disconnect := serve(t, game)()
+	defer disconnect()
+	eventually(t, lobby, "player isn't transferred to the lobby", players(1))
+	eventually(t, game, "player is still in the game", func(r *Room) bool {
+		// the counter of the room itself is left
+		return r.PlayersCount() == 0 && len(r.elements) == 1
+	})
+}
+
+func TestRepeatedOfEveryTickIsWrittenOnce(t *testing.T) {
+	var buf bytes.Buffer
+	room := lobbyRoom()
+	room.SetLogger(logging.NewWithInterval(&buf, logging.InfoLevel, time.Hour))
+	for i := 0; i < 5; i++ {
+		room.Step(room.TickDuration())
+		room.logAt(nil).Repeated(logging.WarnLevel, "overload!")
+	}
+	if n := strings.Count(buf.String(), "overload!"); n != 1 {
+		t.Fatalf("repeated message is written %d times:\n%s", n, buf.String())
+	}
+}
+
+// sleeper makes Update of the room take Delay on the moves Slow says
+type sleeper struct {
+	counter
+	Delay time.Duration
+	Slow  func(move int) bool
+}
+
+func (s *sleeper) Move(time.Duration, elements.EventProcessor) error {
+	s.Moves++
+	if s.Slow(s.Moves) {
+		time.Sleep(s.Delay)
+	}
+	return nil
+}
+
+// run starts a room of the sleeper, waits for some time and says how many ticks it made and steps it dropped
+func run(t *testing.T, s *sleeper, opts ...RoomOption) (ticks, elapsed time.Duration, overloads float64) {
+	reg := metrics.NewRegistry()
+	room := NewBasicRoom(0, "slow", []elements.Element{s}, append(opts, WithTickRate(100), WithLogger(logging.Discard()))...)
+	room.meter = newMeter(reg, "slow")
+	start := time.Now()
+	room.Start()
+	defer room.Stop()
+	time.Sleep(500 * time.Millisecond)
+	var n uint64
+	if err := room.Do(func(r *Room) { n = r.CurrentTick() }); err != nil {
+		t.Fatal(err)
+	}
+	overloads, _ = reg.Value("gio_tick_overloads_total", "slow")
+	return time.Duration(n) * room.TickDuration(), time.Since(start), overloads
+}
+
+func TestLoopCatchesUp(t *testing.T) {
+	// the first step takes four of them, the room makes up for it with the next ones
+	ticks, elapsed, overloads := run(t, &sleeper{Delay: 40 * time.Millisecond, Slow: func(m int) bool { return m == 1 }})
+	if overloads != 0 {
+		t.Fatalf("room is overloaded %v times", overloads)
+	}
+	if ticks > elapsed || ticks < elapsed-100*time.Millisecond {
+		t.Fatalf("room made %v of ticks in %v", ticks, elapsed)
+	}
+}
+
+func TestLoopDropsStepsItCantMake(t *testing.T) {
+	// every step takes three of them, so the room is never in time
+	ticks, elapsed, overloads := run(t, &sleeper{Delay: 30 * time.Millisecond, Slow: func(int) bool { return true }}, WithMaxCatchUp(2))
+	if overloads == 0 {
+		t.Fatal("room dropped no steps")
+	}
+	if ticks > elapsed/2 {
+		t.Fatalf("room made %v of ticks in %v, steps are not dropped", ticks, elapsed)
+	}
+}
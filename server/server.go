@@ -1,44 +1,355 @@
 package server
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"nhooyr.io/websocket"
+
+	"github.com/arovesto/gio/config"
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
+	"github.com/arovesto/gio/metrics"
+	"github.com/arovesto/gio/storage"
 )
 
 type Server struct {
-	rooms map[string]*Room
+	conns int64 // websocket connections served now, first field for atomic alignment
+
+	cfg        config.Config
+	rooms      *Registry
+	matchmaker *Matchmaker
+	mux        *http.ServeMux
+
+	emptyRoomTimeout time.Duration
+	sessions         sessionConfig
+	noResume         bool
+	resumeSecret     []byte
+	resumeGrace      time.Duration
+
+	choose Chooser
+	log    logging.Logger
+
+	metrics     *metrics.Registry
+	connections *metrics.Gauge
+	refused     *metrics.Counter
+
+	storage storage.Storage
+
+	httpLock sync.Mutex
+	http     *http.Server // of ListenAndServe, nil until it is called
+}
+
+// Chooser picks room for the new connection, spectate makes the connection a spectator of the room
+type Chooser func(r *http.Request, rooms map[string]*Room) (room *Room, spectate bool, err error)
+
+type ServerOption func(s *Server)
+
+// WithEmptyRoomTimeout sets for how long temporary rooms may stay without players
+func WithEmptyRoomTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.emptyRoomTimeout = d
+	}
+}
+
+// WithSendQueue sets size of every client's send queue and what to do when it is full
+func WithSendQueue(size int, policy QueuePolicy) ServerOption {
+	return func(s *Server) {
+		s.sessions.queueSize = size
+		s.sessions.queuePolicy = policy
+	}
+}
 
-	choose func(rooms map[string]*Room) (*Room, error)
+// WithWriteTimeout sets how long one write to a client may take before client is disconnected
+func WithWriteTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.sessions.writeTimeout = d
+	}
 }
 
-func NewServer(choose func(rooms map[string]*Room) (*Room, error)) http.Handler {
-	m := http.NewServeMux()
-	m.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(`./static`))))
-	m.Handle("/socket", &Server{rooms: map[string]*Room{}, choose: choose})
-	m.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		http.ServeFile(writer, request, "static/index.html")
+// WithResume sets the key resume tokens are signed with and for how long elements of disconnected players
+// are kept. Without it tokens are signed by a random key and are valid until the server restarts
+func WithResume(secret []byte, grace time.Duration) ServerOption {
+	return func(s *Server) {
+		s.resumeSecret = secret
+		s.resumeGrace = grace
+	}
+}
+
+// WithoutResume deletes elements of disconnected players right away, as rooms run without server do
+func WithoutResume() ServerOption {
+	return func(s *Server) {
+		s.noResume = true
+	}
+}
+
+// WithMaxOffenses sets how many bad events client may send in a minute before he is disconnected
+func WithMaxOffenses(n int) ServerOption {
+	return func(s *Server) {
+		s.sessions.maxOffenses = n
+	}
+}
+
+// WithRateLimit sets what every client may send, limits of the config are used otherwise
+func WithRateLimit(l RateLimit) ServerOption {
+	return func(s *Server) {
+		s.sessions.limits = l
+	}
+}
+
+// WithInboxSize sets how many events of one client may wait for the room, the rest are dropped
+func WithInboxSize(n int) ServerOption {
+	return func(s *Server) {
+		s.sessions.inboxSize = n
+	}
+}
+
+// WithServerLogger sets logger of the server, its rooms and sessions, otherwise they write to stderr
+// with the level of the config
+func WithServerLogger(l logging.Logger) ServerOption {
+	return func(s *Server) {
+		s.log = l
+	}
+}
+
+// WithMetrics sets where the server and its rooms report metrics, they are served on /metrics
+func WithMetrics(m *metrics.Registry) ServerOption {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithStorage sets where rooms made WithPersistence are saved, otherwise it is the directory of the config
+func WithStorage(st storage.Storage) ServerOption {
+	return func(s *Server) {
+		s.storage = st
+	}
+}
+
+// NewServer makes server which puts every connection to the room chosen by choose, see SpectateByQuery for
+// the simple choice. Options override values of the config
+func NewServer(cfg config.Config, choose Chooser, opts ...ServerOption) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("bad config: %w", err)
+	}
+	s := &Server{cfg: cfg, choose: choose, emptyRoomTimeout: DefaultEmptyRoomTimeout, sessions: sessionsOf(cfg)}
+	s.resumeGrace = cfg.Websocket.ResumeGrace.Duration
+	for _, o := range opts {
+		o(s)
+	}
+	if s.log == nil {
+		level, err := logging.ParseLevel(cfg.Logging.Type)
+		if err != nil {
+			return nil, fmt.Errorf("bad config: %w", err)
+		}
+		s.log = logging.New(os.Stderr, level)
+	}
+	s.sessions.log = s.log
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+	s.connections = s.metrics.Gauge("gio_connections", "Websocket connections served now.").With()
+	s.refused = s.metrics.Counter("gio_refused_connections_total", "Connections refused as the server is full.").With()
+	s.rooms = NewRegistry(s.emptyRoomTimeout)
+	s.rooms.tps = cfg.General.TickRate
+	s.rooms.log = s.log
+	s.rooms.metrics = s.metrics
+	if s.storage == nil && cfg.Storage.Dir != "" {
+		st, err := storage.NewFS(cfg.Storage.Dir, cfg.Storage.Keep)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open storage: %w", err)
+		}
+		s.storage = st
+	}
+	s.rooms.storage = s.storage
+	s.rooms.persistEvery = cfg.Storage.Interval.Duration
+	s.rooms.recordDir = cfg.Recording.Dir
+	s.matchmaker = NewMatchmaker(s.rooms)
+	s.sessions.overflow = s.matchmaker.overflow
+	if !s.noResume {
+		rs, err := newResumer(s.resumeSecret, s.resumeGrace)
+		if err != nil {
+			s.log.Error("sessions can't be resumed", logging.Err(err))
+		}
+		s.sessions.resume = rs
+	}
+
+	s.mux = http.NewServeMux()
+	static := cfg.General.Static
+	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(static))))
+	s.mux.HandleFunc("/socket", s.serveSocket)
+	s.mux.Handle("/metrics", s.metrics)
+	if cfg.Admin.Token != "" {
+		s.mux.Handle(adminPrefix, newAdmin(cfg.Admin.Token, s.rooms, s.log))
+	}
+	s.mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeFile(writer, request, filepath.Join(static, "index.html"))
 	})
-	return m
+	return s, nil
+}
+
+// sessionsOf makes websocket limits of the config
+func sessionsOf(cfg config.Config) sessionConfig {
+	w := cfg.Websocket
+	sc := sessionConfig{
+		queueSize:    w.SendQueue,
+		writeTimeout: w.WriteTimeout.Duration,
+		maxOffenses:  w.MaxOffenses,
+		inboxSize:    w.InboxSize,
+		limits: RateLimit{
+			Messages:     w.MessagesPerSecond,
+			MessageBurst: w.MessageBurst,
+			Bytes:        w.BytesPerSecond,
+			ByteBurst:    w.ByteBurst,
+			MaxFrame:     w.MaxFrame,
+		},
+	}
+	if w.QueuePolicy == "disconnect" {
+		sc.queuePolicy = Disconnect
+	}
+	switch w.LimitAction {
+	case "offense":
+		sc.limits.Action = CountOffense
+	case "disconnect":
+		sc.limits.Action = DisconnectFlooder
+	}
+	return sc
+}
+
+// Config is what the server was made with, options are not applied to it
+func (s *Server) Config() config.Config {
+	return s.cfg
+}
+
+// Logger is the logger of the server, rooms add their fields to it
+func (s *Server) Logger() logging.Logger {
+	return s.log
+}
+
+// Metrics are metrics of the server and its rooms
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics
+}
+
+// ListenAndServe serves on the address of the config until Shutdown, it returns http.ErrServerClosed then
+func (s *Server) ListenAndServe() error {
+	s.httpLock.Lock()
+	if s.http == nil {
+		s.http = &http.Server{Addr: s.cfg.General.Address, Handler: s}
+	}
+	srv := s.http
+	s.httpLock.Unlock()
+	return srv.ListenAndServe()
+}
+
+// Shutdown stops accepting connections, then stops every room of the server and waits until they are saved and
+// stopped, or until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.httpLock.Lock()
+	if s.http == nil {
+		s.http = &http.Server{Addr: s.cfg.General.Address, Handler: s}
+	}
+	srv := s.http
+	s.httpLock.Unlock()
+	// websockets are hijacked, Shutdown doesn't wait for them and rooms close them
+	err := srv.Shutdown(ctx)
+	s.rooms.Close()
+	// so no instances are made of the stopped rooms
+	s.matchmaker.Close()
+	if stopErr := s.rooms.StopAll(ctx); stopErr != nil {
+		return stopErr
+	}
+	return err
+}
+
+// Rooms is a registry of all rooms of the server
+func (s *Server) Rooms() *Registry {
+	return s.rooms
+}
+
+// Matchmaker puts players to the rooms of its pools, players who can't join a full room of a pool
+// are sent to another one
+func (s *Server) Matchmaker() *Matchmaker {
+	return s.matchmaker
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c, err := websocket.Accept(w, r, nil)
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: event.Subprotocols()})
 	if err != nil {
-		log.Printf("failed to create websocket connection: %v", err)
+		s.log.Warn("failed to create websocket connection", logging.Err(err))
 		return
 	}
 	defer func() {
 		_ = c.Close(websocket.StatusInternalError, "something wrong happened")
 	}()
+	defer atomic.AddInt64(&s.conns, -1)
+	defer s.connections.Add(-1)
+	n := atomic.AddInt64(&s.conns, 1)
+	s.connections.Add(1)
+	// the cap is what the server can hold, players queued by the matchmaker would keep their connections open
+	// and hold it too, so the ones over it are refused and come back later. Full pools queue players instead
+	if s.cfg.General.ClientsCap > 0 && n > int64(s.cfg.General.ClientsCap) {
+		s.refused.Inc()
+		s.log.Repeated(logging.WarnLevel, "server is full, connection is refused", logging.F("cap", s.cfg.General.ClientsCap))
+		_ = c.Close(websocket.StatusTryAgainLater, "server is full")
+		return
+	}
 
-	room, err := s.choose(s.rooms)
-	if err != nil {
-		log.Printf("failed to get room: %v", err)
+	room, claim := s.resumed(r)
+	spectate := false
+	if room == nil {
+		if room, spectate, err = s.choose(r, s.rooms.Rooms()); err == nil && room == nil {
+			err = RoomNotFound // e.g. the stopped room was removed from the registry
+		}
+		if err != nil {
+			s.log.Warn("failed to get room", logging.Err(err))
+			_ = c.Close(websocket.StatusTryAgainLater, "no room for the connection")
+			return
+		}
+	}
+	if err = room.runWith(c, s.sessions, claim, spectate); errors.Is(err, ResumeFailed) {
+		_ = c.Close(websocket.StatusPolicyViolation, err.Error())
 		return
 	}
-	if err = room.Run(c); err != nil && websocket.CloseStatus(err) != websocket.StatusNormalClosure {
-		log.Printf("failed to run room: %v", err)
+	if err != nil && !errors.Is(err, RoomStopped) && websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+		room.Logger().Warn("failed to run room", logging.Err(err))
+	}
+}
+
+// SpectateByQuery is Chooser which puts every connection to the room chosen by choose,
+// connections with "spectate" query parameter are spectators of the room
+func SpectateByQuery(choose func(rooms map[string]*Room) (*Room, error)) Chooser {
+	return func(r *http.Request, rooms map[string]*Room) (*Room, bool, error) {
+		room, err := choose(rooms)
+		return room, r.URL.Query().Get("spectate") != "", err
+	}
+}
+
+// resumed finds room of the client who came back with the resume token
+func (s *Server) resumed(r *http.Request) (*Room, *resumeClaim) {
+	token := r.URL.Query().Get("resume")
+	if token == "" || s.sessions.resume == nil {
+		return nil, nil
+	}
+	c, err := s.sessions.resume.verify(token)
+	if err != nil {
+		s.log.Info("failed to resume session", logging.Err(err))
+		return nil, nil
+	}
+	room, ok := s.rooms.Lookup(c.Room)
+	if !ok {
+		return nil, nil
 	}
+	return room, &c
 }
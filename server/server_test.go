@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/arovesto/gio/config"
+	"github.com/arovesto/gio/logging"
+)
+
+func TestConnectionWithoutRoomIsClosed(t *testing.T) {
+	// the lobby is chosen, but it is stopped and no longer in the registry
+	srv, err := NewServer(config.Default(), SpectateByQuery(func(rooms map[string]*Room) (*Room, error) {
+		return rooms["lobby"], nil
+	}), WithServerLogger(logging.Discard()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer func() { _ = srv.Shutdown(ctx) }()
+	for i := 0; i < 2; i++ {
+		c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(hs.URL, "http")+"/socket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = c.Read(ctx); websocket.CloseStatus(err) != websocket.StatusTryAgainLater {
+			t.Fatalf("connection without room is closed with %v", err)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/arovesto/gio/elements"
+	"github.com/arovesto/gio/event"
+	"github.com/arovesto/gio/logging"
+)
+
+// unreliableEvents may be dropped if client can't keep up, next ones would bring the same news
+var unreliableEvents = map[string]bool{"update": true, "patch": true}
+
+const (
+	DefaultMaxOffenses = 10
+	// offenses older than that are forgiven
+	offenseWindow = time.Minute
+)
+
+type sessionConfig struct {
+	queueSize    int
+	queuePolicy  QueuePolicy
+	writeTimeout time.Duration
+	resume       *resumer // nil if clients can't resume sessions
+	maxOffenses  int
+	limits       RateLimit
+	inboxSize    int
+	overflow     func(full *Room) (*Room, error) // finds another room when the chosen one is full
+	log          logging.Logger
+}
+
+// session is a connection of one client, it lives through transfers between rooms.
+// Session goroutines do only I/O, everything else is done by the room goroutine
+type session struct {
+	c        *websocket.Conn
+	ctx      context.Context
+	frames   chan []byte
+	errs     chan error
+	transfer chan *Room
+	codec    event.Codec
+	out      *outbound
+	kickOnce sync.Once
+	resume   *resumer
+	claim    *resumeClaim // element client wants back, used by the first room only
+
+	spectator bool // client watches rooms without element and sends nothing
+	overflow  func(full *Room) (*Room, error)
+
+	limit limiter
+	in    *inbox // events waiting for the room
+
+	maxOffenses int
+	offenses    int // events client was not allowed to send
+	lastOffense time.Time
+	forfeit     int32 // client was disconnected for bad behaviour and can't resume, see giveUp
+
+	log   logging.Logger // of the connection, the room logs with the player id instead
+	meter *meter         // of the room session is in, used by the room goroutine
+}
+
+func newSession(ctx context.Context, c *websocket.Conn, cfg sessionConfig) *session {
+	codec := event.CodecFor(c.Subprotocol())
+	ss := &session{
+		c:        c,
+		ctx:      ctx,
+		frames:   make(chan []byte),
+		errs:     make(chan error, 1),
+		transfer: make(chan *Room, 1),
+		codec:    codec,
+		out:      newOutbound(cfg.queueSize, cfg.queuePolicy, cfg.writeTimeout, codec.Binary()),
+		resume:   cfg.resume,
+
+		limit:       newLimiter(cfg.limits),
+		in:          newInbox(cfg.inboxSize),
+		overflow:    cfg.overflow,
+		maxOffenses: cfg.maxOffenses,
+		log:         cfg.log,
+	}
+	if ss.log == nil {
+		ss.log = logging.Default()
+	}
+	if cfg.limits.MaxFrame > 0 {
+		c.SetReadLimit(cfg.limits.MaxFrame)
+	}
+	if ss.maxOffenses <= 0 {
+		ss.maxOffenses = DefaultMaxOffenses
+	}
+	go ss.read()
+	go ss.write()
+	return ss
+}
+
+func (ss *session) write() {
+	if err := ss.out.write(ss.ctx, ss.c); err != nil && ss.ctx.Err() == nil {
+		ss.log.Warn("failed to write to client", logging.Err(err))
+		ss.kick(websocket.StatusInternalError, "failed to write")
+	}
+}
+
+// kick closes the connection, reader notices it and the session ends
+func (ss *session) kick(code websocket.StatusCode, reason string) {
+	ss.kickOnce.Do(func() {
+		// close waits for the other side to answer, so don't block on slow clients
+		go func() {
+			_ = ss.c.Close(code, reason)
+		}()
+	})
+}
+
+func (ss *session) read() {
+	for {
+		_, data, err := ss.c.Read(ss.ctx)
+		if err != nil {
+			ss.errs <- err
+			return
+		}
+		select {
+		case ss.frames <- data:
+		case <-ss.ctx.Done():
+			return
+		}
+	}
+}
+
+// run serves the client in the room and every room it is transferred to
+func (ss *session) run(r *Room) error {
+	for r != nil {
+		next, err := ss.serve(r)
+		if err != nil {
+			return err
+		}
+		r = next
+	}
+	return nil
+}
+
+func (ss *session) serve(r *Room) (*Room, error) {
+	r.Start()
+	me, next, err := r.join(ss)
+	if errors.Is(err, RoomFull) && ss.overflow != nil {
+		return ss.overflow(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if next != nil {
+		return next, nil
+	}
+
+	// TODO handle "connection closed" appropriately
+	defer r.leave(me, ss)
+	lg := r.Logger().With(logging.Player(me))
+
+	for {
+		select {
+		case next := <-ss.transfer:
+			return next, nil
+		case <-r.done:
+			return nil, RoomStopped
+		case err := <-ss.errs:
+			select {
+			case <-r.done:
+				return nil, RoomStopped
+			default:
+				return nil, err
+			}
+		case data := <-ss.frames:
+			r.meter.received(len(data))
+			if !ss.limit.allow(len(data)) {
+				ss.flooded(r, me)
+				continue
+			}
+			ev, ok := ss.authorize(r, me, data)
+			if !ok {
+				continue
+			}
+			if !ss.in.push(ev) {
+				r.meter.drop(droppedInbox)
+				lg.Repeated(logging.WarnLevel, "overload! event is not pushed", logging.F("event", ev.Type))
+			}
+		}
+	}
+}
+
+// authorize checks event client sent in the room, where he is the player me.
+// Client may send only events of types room allows and only from his own element, spectators may send nothing.
+// From of "add" is the type of the new element, it must be known, the room makes sure the element is new
+func (ss *session) authorize(r *Room, me int, data []byte) (event.Event, bool) {
+	ev, err := ss.codec.Decode(data)
+	switch {
+	case err != nil:
+		ss.offend(r, me, fmt.Sprintf("malformed event: %v", err))
+	case ss.spectator:
+		ss.offend(r, me, fmt.Sprintf("spectator sent %q", ev.Type))
+	case !r.allowed(ev.Type):
+		ss.offend(r, me, fmt.Sprintf("event %q is not allowed", ev.Type))
+	case ev.Type == "add":
+		if _, ok := elements.GenElements[ev.From]; ok {
+			return ev, true
+		}
+		ss.offend(r, me, fmt.Sprintf("element type %d is unknown", ev.From))
+	case ev.From != me:
+		ss.offend(r, me, fmt.Sprintf("event %q is sent from %d", ev.Type, ev.From))
+	default:
+		return ev, true
+	}
+	return ev, false
+}
+
+// offend counts event which was dropped, client who does it too often is disconnected
+func (ss *session) offend(r *Room, me int, reason string) {
+	r.reject()
+	r.Logger().Warn("dropped event", logging.Player(me), logging.F("reason", reason))
+	if time.Since(ss.lastOffense) > offenseWindow {
+		ss.offenses = 0
+	}
+	ss.offenses++
+	ss.lastOffense = time.Now()
+	if ss.offenses >= ss.maxOffenses {
+		ss.giveUp()
+		ss.kick(websocket.StatusPolicyViolation, "too many bad events")
+	}
+}
+
+// giveUp takes away the right to resume, it is done by both the session and the room goroutines
+func (ss *session) giveUp() {
+	atomic.StoreInt32(&ss.forfeit, 1)
+}
+
+func (ss *session) forfeited() bool {
+	return atomic.LoadInt32(&ss.forfeit) == 1
+}
+
+// flooded acts on the frame over the rate limit
+func (ss *session) flooded(r *Room, me int) {
+	switch ss.limit.action {
+	case CountOffense:
+		ss.offend(r, me, "rate limit exceeded")
+	case DisconnectFlooder:
+		r.reject()
+		ss.giveUp()
+		ss.kick(websocket.StatusPolicyViolation, "rate limit exceeded")
+	default:
+		r.reject()
+	}
+}
+
+func (ss *session) send(e event.Event) error {
+	return ss.sendAs(e, !unreliableEvents[e.Type])
+}
+
+func (ss *session) sendAs(e event.Event, reliable bool) error {
+	data, err := ss.codec.Encode(e)
+	if err != nil {
+		return err
+	}
+	return ss.push(data, reliable)
+}
+
+// push puts message to the send queue, client who can't keep up is disconnected
+func (ss *session) push(data []byte, reliable bool) error {
+	if err := ss.out.push(message{data: data, reliable: reliable, meter: ss.meter}); err != nil {
+		ss.kick(websocket.StatusPolicyViolation, err.Error())
+		return err
+	}
+	return nil
+}
+
+// join is done by the room goroutine: player is chosen by join policy and gets the room snapshot.
+// Resuming client gets his element back instead, or is sent to the next room if he was transferred while away
+func (s *Room) join(ss *session) (me int, next *Room, err error) {
+	doErr := s.do(func() {
+		if c := ss.claim; c != nil {
+			ss.claim = nil
+			me = c.ID
+			next, err = s.reattach(ss, *c)
+			return
+		}
+		if ss.spectator {
+			me, err = s.watch(ss)
+			return
+		}
+		if s.maxPlayers > 0 && s.PlayersCount() >= s.maxPlayers {
+			err = RoomFull
+			return
+		}
+		assigned := map[int]struct{}{}
+		for id := range s.clients {
+			assigned[id] = struct{}{}
+		}
+		for id := range s.away {
+			assigned[id] = struct{}{}
+		}
+		join := s.joinPolicy
+		if join == nil {
+			join = PlayerChoiceFunctions[s.Type]
+		}
+		if join == nil {
+			err = fmt.Errorf("room %s has no join policy", s.Type)
+			return
+		}
+		me, err = join(s.players, assigned, s)
+		if err != nil {
+			// TODO Mange "Room Full" error appropriately (or not)
+			return
+		}
+		if _, ok := s.players[me]; !ok {
+			err = EntityNotFound
+			return
+		}
+		err = s.attach(ss, me)
+	})
+	if doErr != nil {
+		return 0, nil, doErr
+	}
+	return
+}
+
+// attach makes element me the player of the session
+func (s *Room) attach(ss *session, me int) error {
+	ss.in.clear() // events left from the previous room are not about this one
+	ss.meter = s.meter
+	p := &player{session: ss}
+	if err := s.greet(p, me); err != nil {
+		s.DeleteElement(me)
+		return err
+	}
+	s.clientsLock.Lock()
+	s.clients[me] = p
+	s.clientsLock.Unlock()
+	return nil
+}
+
+// greet sends the room snapshot and tells client which element is his
+func (s *Room) greet(p *player, me int) error {
+	if s.interestManaged() {
+		p.visible = s.interesting(me)
+	}
+	roomData, err := s.stateFor(p)
+	if err != nil {
+		return err
+	}
+	if err = p.session.send(event.Event{Type: "room", Payload: roomData}); err != nil {
+		return err
+	}
+	s.remember(p)
+	p.dropped = p.session.out.Stats().Dropped
+	if spectator(me) {
+		return p.session.send(spectation())
+	}
+	assign, err := s.assignment(p, me)
+	if err != nil {
+		return err
+	}
+	return p.session.send(assign)
+}
+
+// leave removes player of the session, if it is not already transferred or removed.
+// Element of the player who can resume is kept frozen for a while
+func (s *Room) leave(me int, ss *session) {
+	_ = s.do(func() {
+		s.clientsLock.Lock()
+		p, ok := s.clients[me]
+		if !ok || p.session != ss {
+			s.clientsLock.Unlock()
+			return
+		}
+		delete(s.clients, me)
+		if !spectator(me) && !s.playing() {
+			s.emptySince = time.Now()
+		}
+		s.clientsLock.Unlock()
+		if !s.keepAway(me, p) {
+			s.DeleteElement(me)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/arovesto/gio/event"
+)
+
+func TestRoomRejectsAddOfUnknownType(t *testing.T) {
+	room := lobbyRoom()
+	err := room.ProcessEvent(event.Event{Type: "add", From: -12345, Payload: json.RawMessage(`{}`)})
+	if !errors.Is(err, UnknownElement) {
+		t.Fatalf("add of unknown type is %v", err)
+	}
+}
+
+func TestClientCantReplaceElement(t *testing.T) {
+	room := lobbyRoom()
+	id := room.NewID()
+	room.NewElement(&counter{ID: id, Lives: 3})
+	st, _ := json.Marshal(counter{ID: id})
+	if err := room.admit(event.Event{Type: "add", From: counterType, Payload: st}); !errors.Is(err, ElementExists) {
+		t.Fatalf("add over the element is %v", err)
+	}
+	st, _ = json.Marshal(counter{ID: id + 1})
+	if err := room.admit(event.Event{Type: "add", From: counterType, Payload: st}); err != nil {
+		t.Fatalf("add of the new element is %v", err)
+	}
+}
+
+func TestAddIsAuthorizedByType(t *testing.T) {
+	room := NewBasicRoom(0, "lobby", nil, WithClientEvents("input", "add"))
+	ss := &session{codec: event.JSON, maxOffenses: 100}
+	known, _ := json.Marshal(event.Event{Type: "add", From: counterType, Payload: json.RawMessage(`{}`)})
+	if _, ok := ss.authorize(room, 7, known); !ok {
+		t.Fatal("add of known type is not authorized")
+	}
+	unknown, _ := json.Marshal(event.Event{Type: "add", From: 7, Payload: json.RawMessage(`{}`)})
+	if _, ok := ss.authorize(room, 7, unknown); ok {
+		t.Fatal("add of unknown type is authorized")
+	}
+	if ss.offenses != 1 {
+		t.Fatalf("%d offenses, want 1", ss.offenses)
+	}
+}
+
+// wsPair is the server and the client sides of one websocket connection
+func wsPair(t *testing.T) (srv, cl *websocket.Conn) {
+	conns := make(chan *websocket.Conn)
+	done := make(chan struct{})
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- c
+		<-done
+	}))
+	t.Cleanup(func() {
+		close(done)
+		hs.Close()
+	})
+	cl, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return <-conns, cl
+}
+
+func frame(t *testing.T, e event.Event) []byte {
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAuthorize(t *testing.T) {
+	const me = 3
+	cases := []struct {
+		name      string
+		spectator bool
+		data      []byte
+		ok        bool
+	}{
+		{"own input", false, frame(t, event.Event{Type: "input", From: me}), true},
+		{"malformed", false, []byte("{"), false},
+		{"spectator", true, frame(t, event.Event{Type: "input", From: me}), false},
+		{"not allowed type", false, frame(t, event.Event{Type: "delete", From: me}), false},
+		{"from another player", false, frame(t, event.Event{Type: "input", From: me + 1}), false},
+	}
+	for _, c := range cases {
+		room := lobbyRoom()
+		ss := &session{codec: event.JSON, maxOffenses: 100, spectator: c.spectator}
+		if _, ok := ss.authorize(room, me, c.data); ok != c.ok {
+			t.Errorf("%s: authorized is %v", c.name, ok)
+		}
+		offended := 0
+		if !c.ok {
+			offended = 1
+		}
+		if ss.offenses != offended || room.RejectedEvents() != uint64(offended) {
+			t.Errorf("%s: %d offenses and %d rejected events", c.name, ss.offenses, room.RejectedEvents())
+		}
+	}
+}
+
+func TestOffensesAreForgivenAfterWindow(t *testing.T) {
+	ss := &session{codec: event.JSON, maxOffenses: 3, offenses: 2, lastOffense: time.Now().Add(-2 * offenseWindow)}
+	ss.offend(lobbyRoom(), 1, "test")
+	if ss.offenses != 1 {
+		t.Fatalf("%d offenses, old ones are not forgiven", ss.offenses)
+	}
+}
+
+func TestTooManyOffensesKick(t *testing.T) {
+	srv, cl := wsPair(t)
+	rs, err := newResumer([]byte("secret"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := &session{c: srv, codec: event.JSON, maxOffenses: 3, resume: rs}
+	room := lobbyRoom()
+	room.Start()
+	defer room.Stop()
+	for i := 0; i < 2; i++ {
+		ss.offend(room, 1, "test")
+	}
+	if ss.forfeited() {
+		t.Fatal("client is kicked before max offenses")
+	}
+	ss.offend(room, 1, "test")
+	var kept bool
+	_ = room.Do(func(r *Room) { kept = r.keepAway(1, &player{session: ss, nonce: "nonce"}) })
+	if kept {
+		t.Fatal("element of the kicked client is kept for him")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err = cl.Read(ctx)
+	if websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+		t.Fatalf("connection is not closed for policy violation: %v", err)
+	}
+}
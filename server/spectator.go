@@ -0,0 +1,32 @@
+package server
+
+import "github.com/arovesto/gio/event"
+
+// spectators are clients too, they are kept under negative keys as they own no element
+func spectator(id int) bool {
+	return id < 0
+}
+
+// watch makes the session a spectator of the room, must be called by the room goroutine
+func (s *Room) watch(ss *session) (int, error) {
+	s.watchers++
+	me := -s.watchers
+	return me, s.attach(ss, me)
+}
+
+// spectation tells client he is a spectator, it is sent instead of "assign"
+func spectation() event.Event {
+	return event.Event{Type: "spectate"}
+}
+
+// Spectators is how many clients watch the room without playing
+func (s *Room) Spectators() (r int) {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	for id := range s.clients {
+		if spectator(id) {
+			r++
+		}
+	}
+	return
+}
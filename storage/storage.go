@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	NotFound = errors.New("snapshot not found")
+	BadKey   = errors.New("bad snapshot key")
+)
+
+// DefaultKeep is how many versions of every snapshot FS keeps
+const DefaultKeep = 5
+
+// Storage keeps snapshots of rooms by their keys
+type Storage interface {
+	// Save stores data as the latest version of the snapshot
+	Save(key string, data []byte) error
+	// Load is the latest version of the snapshot, NotFound if there is none
+	Load(key string) ([]byte, error)
+}
+
+const snapshotExt = ".json"
+
+// FS keeps every snapshot in its own directory as numbered files, the biggest number is the latest one:
+//
+//	dir/lobby/00000000000000000041.json
+//
+// New version is written to a temporary file and renamed, so readers never see half written snapshot
+type FS struct {
+	lock sync.Mutex
+	dir  string
+	keep int
+}
+
+// NewFS makes storage in dir, it keeps keep latest versions of every snapshot, DefaultKeep if it is not positive
+func NewFS(dir string, keep int) (*FS, error) {
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FS{dir: dir, keep: keep}, nil
+}
+
+func (f *FS) Save(key string, data []byte) error {
+	dir, err := f.keyDir(key)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	versions, err := versionsIn(dir)
+	if err != nil {
+		return err
+	}
+	var next uint64
+	if len(versions) != 0 {
+		next = versions[len(versions)-1] + 1
+	}
+	if err := writeAtomic(dir, versionName(next), data); err != nil {
+		return err
+	}
+	versions = append(versions, next)
+	for _, v := range versions[:len(versions)-min(len(versions), f.keep)] {
+		if err := os.Remove(filepath.Join(dir, versionName(v))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FS) Load(key string) ([]byte, error) {
+	dir, err := f.keyDir(key)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := versionsIn(dir)
+	if os.IsNotExist(err) || err == nil && len(versions) == 0 {
+		return nil, NotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filepath.Join(dir, versionName(versions[len(versions)-1])))
+}
+
+// Versions are numbers of the kept versions of the snapshot, the oldest first
+func (f *FS) Versions(key string) ([]uint64, error) {
+	dir, err := f.keyDir(key)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := versionsIn(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return versions, err
+}
+
+// keyDir is the directory of the snapshot, keys can't point outside of the storage
+func (f *FS) keyDir(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("%w %q", BadKey, key)
+	}
+	return filepath.Join(f.dir, key), nil
+}
+
+func versionName(v uint64) string {
+	return fmt.Sprintf("%020d%s", v, snapshotExt)
+}
+
+// versionsIn are versions in the directory sorted from the oldest, temporary and foreign files are skipped
+func versionsIn(dir string) ([]uint64, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var versions []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSuffix(name, snapshotExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+// writeAtomic writes data to the temporary file, syncs it and renames to name
+func writeAtomic(dir, name string, data []byte) (err error) {
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	// rename itself must survive the crash too
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
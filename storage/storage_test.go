@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func newFS(t *testing.T, keep int) (*FS, string) {
+	dir := t.TempDir()
+	f, err := NewFS(dir, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, dir
+}
+
+func TestLoadReturnsTheLatest(t *testing.T) {
+	f, _ := newFS(t, 3)
+	if _, err := f.Load("lobby"); !errors.Is(err, NotFound) {
+		t.Fatalf("missing snapshot is %v", err)
+	}
+	for i := 0; i < 12; i++ {
+		if err := f.Save("lobby", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Save("game", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := f.Load("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "11" {
+		t.Fatalf("loaded %q, want the latest one", data)
+	}
+}
+
+func TestSaveKeepsLatestVersions(t *testing.T) {
+	f, dir := newFS(t, 3)
+	for i := 0; i < 5; i++ {
+		if err := f.Save("lobby", []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := f.Versions("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0] != 2 || versions[2] != 4 {
+		t.Fatalf("versions %v, want 2..4", versions)
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "lobby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d files in the snapshot directory, want 3", len(entries))
+	}
+}
+
+func TestFailedSaveLeavesNothing(t *testing.T) {
+	f, dir := newFS(t, 3)
+	// the version can't be renamed over a directory
+	if err := os.MkdirAll(filepath.Join(dir, "lobby", versionName(0)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Save("lobby", []byte("{}")); err == nil {
+		t.Fatal("save over the directory succeeded")
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "lobby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		t.Fatalf("temporary file is left: %v", entries)
+	}
+	if _, err := f.Load("lobby"); !errors.Is(err, NotFound) {
+		t.Fatalf("snapshot of the failed save is %v", err)
+	}
+}
+
+func TestLoadSkipsTemporaryFiles(t *testing.T) {
+	f, dir := newFS(t, 3)
+	if err := f.Save("lobby", []byte("saved")); err != nil {
+		t.Fatal(err)
+	}
+	// crash in the middle of the next save
+	tmp := filepath.Join(dir, "lobby", "."+versionName(1)+".tmp-123")
+	if err := ioutil.WriteFile(tmp, []byte("half"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := f.Load("lobby")
+	if err != nil || string(data) != "saved" {
+		t.Fatalf("loaded %q %v, want the saved one", data, err)
+	}
+}
+
+func TestBadKeys(t *testing.T) {
+	f, _ := newFS(t, 1)
+	for _, key := range []string{"", ".", "..", "../x", `a\b`} {
+		if err := f.Save(key, nil); !errors.Is(err, BadKey) {
+			t.Errorf("key %q is %v", key, err)
+		}
+	}
+}